@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+
+	"github.com/sdmccabe/schelling-go/schelling"
+)
+
+func analyze(args []string) {
+	// Measure the models in a file written by -save, -snapshot-file or
+	// -dump-final, or a model as printed by -v, without simulating. The
+	// measurements are written to standard output as CSV, a row for each
+	// model in the file.
+
+	fs := flag.NewFlagSet(os.Args[0]+" "+cmdAnalyze, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [flags] file\n", os.Args[0], cmdAnalyze)
+		fs.PrintDefaults()
+	}
+	var cfg schelling.Config
+	fs.IntVar(&cfg.Vision, "w", 0, "neighborhood size the agents see")
+	fs.Float64Var(&cfg.Tolerance, "t", 0, "agent tolerance, for counting unhappy agents")
+	fs.IntVar(&cfg.Groups, "k", 2, "number of groups (agent types)")
+	fs.StringVar(&cfg.Topology, "topology", schelling.Ring, "ring to wrap around the edges of the model, line not to")
+	fs.StringVar(&cfg.Neighborhood, "neighborhood", "", "cells an agent sees on a grid, moore or vonneumann. defaults to moore")
+	fs.BoolVar(&cfg.Strict, "strict", false, "agents are happy only if the fraction of same-type neighbors exceeds their tolerance. by default, meeting it is enough")
+	fs.StringVar(&cfg.Weights, "weights", schelling.Uniform, "how much neighbors count by distance: uniform, or falling off linear or gaussian")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Please enter the file holding the model to analyze.")
+		os.Exit(1)
+	}
+	if cfg.Vision <= 0 {
+		fmt.Fprintln(os.Stderr, "Please enter the desired neighborhood size.")
+		os.Exit(1)
+	}
+	snapshots, err := readModels(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: bad model: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("tick,blocks,interfaces,cluster.min,cluster.max,cluster.mean,segregation,entropy,unhappy")
+	for _, s := range snapshots {
+		c := cfg
+		c.Layout, c.Width, c.Height = s.Layout, s.Width, s.Height
+		c.Size = len(c.Layout)
+		c.Dim = 1
+		if c.Height > 1 {
+			c.Dim = 2
+		}
+		if err := c.Validate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: model at tick %d: %v.\n", s.Tick, err)
+			os.Exit(1)
+		}
+		// a model laid out in full draws nothing at random
+		model := schelling.New(c, rand.New(rand.NewSource(0)))
+		minCluster, maxCluster, meanCluster := clusterStats(model.ClusterSizes())
+		fmt.Printf("%d,%d,%.6f,%d,%d,%.6f,%.6f,%.6f,%d\n", s.Tick, model.CountDistinct(), model.Interfaces(),
+			minCluster, maxCluster, meanCluster, model.Segregation(), model.Entropy(), model.Unhappy())
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sdmccabe/schelling-go/schelling"
+)
+
+// maxAnimateCells is the largest model -animate will draw; bigger models
+// don't fit on a terminal screen.
+const maxAnimateCells = 10000
+
+// ANSI escape sequences to move the cursor to the top left corner of the
+// terminal and to clear the screen from there down.
+const (
+	cursorHome  = "\033[H"
+	clearScreen = "\033[J"
+)
+
+func runAnimated(ctx context.Context, model *schelling.Model, maxTicks int, fps int) (int64, bool, error) {
+	// Equivalent to model.RunToEquilibriumContext, but redraw the model in
+	// place after every tick, at most fps times a second.
+
+	frame := time.NewTicker(time.Second / time.Duration(fps))
+	defer frame.Stop()
+	draw := func(ticks int64) {
+		fmt.Print(cursorHome + clearScreen)
+		fmt.Println(model)
+		fmt.Printf("tick %d, %d moves\n", ticks, model.Moves())
+	}
+
+	draw(1)
+	onStep(model, func(ticks int64, _ *schelling.Model) {
+		select {
+		case <-ctx.Done():
+			return // run out the ticks until the run notices, undrawn
+		case <-frame.C:
+		}
+		draw(ticks)
+	})
+	ticks, ok, err := model.RunToEquilibriumContext(ctx, maxTicks)
+	if err == nil {
+		err = ctx.Err() // cancelled, but converged before the run noticed
+	}
+	if err != nil {
+		return ticks, false, err
+	}
+	if !ok {
+		fmt.Println("Model failed to stabilize")
+	}
+	return ticks, ok, nil
+}
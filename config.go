@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+func applyConfigFile(fs *flag.FlagSet, name string) error {
+	// Set the flags of fs from the JSON file name, an object mapping flag
+	// names to values, such as {"s": 1000, "w": "1:8:1", "t": [0.3, 0.5]},
+	// so a whole experiment, sweep ranges and all, can be kept and shared as
+	// one file. A list of values is joined with commas, as a sweep flag takes
+	// them. Flags given on the command line are left as they are, so they
+	// override the file.
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+
+	given := make(map[string]bool) // on the command line
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	// in a fixed order, so the first error is always the same
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key == "config" || fs.Lookup(key) == nil {
+			return fmt.Errorf("%s is not a flag of this command", key)
+		}
+		if given[key] {
+			continue
+		}
+		value, err := configValue(values[key])
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+		if err := fs.Set(key, value); err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+func configValue(raw json.RawMessage) (string, error) {
+	// Return a value of a config file as a flag would be given it: a string
+	// as it is, a number or boolean as written, and a list of these joined
+	// with commas.
+
+	var list []json.RawMessage
+	if json.Unmarshal(raw, &list) == nil {
+		items := make([]string, len(list))
+		for i, item := range list {
+			v, err := configValue(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = v
+		}
+		return strings.Join(items, ","), nil
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s, nil
+	}
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return "", err
+	}
+	switch v.(type) {
+	case float64, bool:
+		return string(bytes.TrimSpace(raw)), nil // as written, so large seeds keep every digit
+	}
+	return "", fmt.Errorf("%s is not a string, number, boolean or list", raw)
+}
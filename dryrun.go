@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/sdmccabe/schelling-go/schelling"
+)
+
+func printDryRun(configs []schelling.Config, set settings, numRuns int, timeout time.Duration) {
+	// Print the configuration of a batch as it would be run, with every
+	// default filled in, and check that the output file can be written.
+
+	fmt.Println("Dry run: nothing will be simulated.")
+	if set.runSeedSet {
+		fmt.Printf("run seed: %d\n", set.runSeed)
+	} else {
+		fmt.Printf("seed: %d\n", set.seed)
+	}
+	fmt.Printf("GOMAXPROCS: %d\n", runtime.GOMAXPROCS(0))
+	if set.parallel {
+		fmt.Printf("workers: %d", set.numWorkers)
+		if set.numWorkers > numRuns {
+			fmt.Printf(" (only %d have a run to do)", numRuns)
+		}
+		fmt.Println()
+	} else {
+		fmt.Println("workers: none, runs are serial")
+	}
+	fmt.Printf("runs: %d for each of %d combinations of parameters, %d in all\n", numRuns, len(configs), numRuns*len(configs))
+
+	c := configs[0].Resolved()
+	maxTicks := set.maxTicks
+	if set.perCell {
+		maxTicks *= c.Size
+	}
+	if set.perCell && set.sizeSweep {
+		fmt.Printf("max ticks per run: %d per agent\n", set.maxTicks)
+	} else {
+		fmt.Printf("max ticks per run: %d\n", maxTicks)
+	}
+	if timeout > 0 {
+		fmt.Printf("timeout: %v\n", timeout)
+	} else {
+		fmt.Println("timeout: none")
+	}
+
+	if c.Dim == 2 {
+		fmt.Printf("model: %dx%d grid, %s topology, %s neighborhood, %d cells\n", c.Width, c.Height, c.Topology, c.Neighborhood, c.Size)
+	} else if set.sizeSweep {
+		fmt.Printf("model: %s of each size below\n", c.Topology)
+	} else {
+		fmt.Printf("model: %s of %d cells\n", c.Topology, c.Size)
+	}
+	if c.Layout != nil {
+		fmt.Println("initial model: from -init")
+	}
+	if c.InitClusters > 0 {
+		fmt.Printf("initial model: %d blocks of a single type\n", c.InitClusters)
+	}
+	fmt.Printf("groups: %d, density: %g", c.Groups, c.Density)
+	if c.Shares != nil {
+		fmt.Printf(", shares: %v", c.Shares)
+		if c.Exact {
+			fmt.Print(" exactly")
+		}
+	}
+	fmt.Println()
+	fmt.Printf("movement: %s, activation: %s, weights: %s, strict: %t\n", c.Movement, c.Activation, c.Weights, c.Strict)
+	if c.Movement == schelling.Random && c.Beta == 0 {
+		fmt.Printf("move attempts: %d places before an unhappy agent gives up\n", c.MoveAttempts)
+	}
+	if c.ConvergeEps > 0 {
+		fmt.Printf("converged: once fewer than %g of the agents are unhappy\n", c.ConvergeEps)
+	}
+	if c.PlateauTicks > 0 {
+		fmt.Printf("plateau: give up after %d ticks without the unhappy agents falling by more than %g of the agents\n", c.PlateauTicks, c.PlateauEps)
+	}
+	if c.Anchored > 0 {
+		fmt.Printf("anchored: %g of the agents never move\n", c.Anchored)
+	}
+	if c.Beta > 0 {
+		fmt.Printf("stochastic moves with beta %g: runs last until the tick cap\n", c.Beta)
+	}
+	if c.ToleranceDist.Kind != "" {
+		fmt.Printf("tolerance distribution: %s\n", c.ToleranceDist.String())
+	}
+	for _, c := range configs {
+		c = c.Resolved()
+		fmt.Print("  ")
+		if set.sizeSweep {
+			fmt.Printf("%d agents, ", c.Size)
+		}
+		fmt.Printf("vision %d", c.Vision)
+		if c.Dim == 1 && c.VisionLeft != c.VisionRight {
+			fmt.Printf(" (left %d, right %d)", c.VisionLeft, c.VisionRight)
+		}
+		if c.ThresholdCount > 0 {
+			fmt.Printf(", at least %d same-type neighbors", c.ThresholdCount)
+		} else {
+			fmt.Printf(", tolerance %g", c.Tolerance)
+		}
+		if c.GroupTolerances != nil {
+			fmt.Printf(" (by type %v)", c.GroupTolerances)
+		}
+		fmt.Println()
+	}
+
+	if set.dumpDir != "" {
+		fmt.Printf("final models: %s", set.dumpDir)
+		if set.dumpGzip {
+			fmt.Print(" (gzip)")
+		}
+		fmt.Println()
+	}
+	if !writeResults {
+		fmt.Println("output: none, as standard output shows the model")
+		return
+	}
+	if filename == "" {
+		fmt.Printf("output: standard output (%s)\n", format)
+		return
+	}
+	fmt.Printf("output: %s (%s)", filename, format)
+	if appendOutput {
+		fmt.Print(", appending")
+	}
+	fmt.Println()
+	if err := checkWritable(filename); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: cannot write the output file: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func checkWritable(name string) error {
+	// Return an error if the file name could not be created, along with any
+	// missing directories above it. Nothing is left behind.
+
+	dir := filepath.Dir(name)
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", dir)
+			}
+			break
+		}
+		if !os.IsNotExist(err) || filepath.Dir(dir) == dir {
+			return err
+		}
+		fmt.Printf("%s will be created\n", dir)
+		dir = filepath.Dir(dir)
+	}
+
+	f, err := os.CreateTemp(dir, ".schelling-dry-run-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/gif"
+	"os"
+
+	"github.com/sdmccabe/schelling-go/schelling"
+)
+
+const (
+	gifScale      = 4   // pixels along each side of a cell
+	gifDelay      = 5   // hundredths of a second between frames
+	gifFinalDelay = 200 // hundredths of a second to hold the last frame
+)
+
+// gifRecorder collects frames of a run for writing as an animated GIF.
+type gifRecorder struct {
+	every   int // ticks between frames
+	palette color.Palette
+	frames  []*image.Paletted
+}
+
+func (g *gifRecorder) capture(model *schelling.Model) {
+	g.frames = append(g.frames, render(model, g.palette, gifScale))
+}
+
+func (g *gifRecorder) write(filename string) error {
+	// Write the captured frames to filename as an animated GIF.
+
+	delays := make([]int, len(g.frames))
+	for i := range delays {
+		delays[i] = gifDelay
+	}
+	delays[len(delays)-1] = gifFinalDelay
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = gif.EncodeAll(f, &gif.GIF{Image: g.frames, Delay: delays})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func runRecorded(ctx context.Context, model *schelling.Model, maxTicks int, every int, capture func(ticks int64)) (int64, bool, error) {
+	// Equivalent to model.RunToEquilibriumContext, but call capture every
+	// every ticks, as well as at the start and the end.
+
+	capture(1)
+	onStep(model, func(ticks int64, _ *schelling.Model) {
+		if (ticks-1)%int64(every) == 0 {
+			capture(ticks)
+		}
+	})
+	ticks, ok, err := model.RunToEquilibriumContext(ctx, maxTicks)
+	if (ticks-1)%int64(every) != 0 { // the last tick has no capture yet
+		capture(ticks)
+	}
+	return ticks, ok, err
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// the longest bar of a histogram printed on standard error
+const histogramWidth = 50
+
+// A bin counts the runs whose number of final groups is from low to high,
+// inclusive.
+type bin struct {
+	low, high int64
+	runs      int
+}
+
+func binCounts(counts map[int64]int, width int) []bin {
+	// Return the bins of the given width, aligned to multiples of it, that
+	// span counts, a number of runs for each number of final groups. Empty
+	// bins between the smallest and largest are included, so gaps show.
+
+	if len(counts) == 0 {
+		return nil
+	}
+	values := make([]int64, 0, len(counts))
+	for v := range counts {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	w := int64(width)
+	first, last := values[0]/w*w, values[len(values)-1]/w*w
+	bins := make([]bin, 0, (last-first)/w+1)
+	for low := first; low <= last; low += w {
+		bins = append(bins, bin{low: low, high: low + w - 1})
+	}
+	for _, v := range values {
+		bins[(v/w*w-first)/w].runs += counts[v]
+	}
+	return bins
+}
+
+func printBins(bins []bin) {
+	// Print bins on standard error as a histogram of bars of #, scaled so the
+	// fullest bin fills histogramWidth.
+
+	most := 0
+	for _, b := range bins {
+		most = max(most, b.runs)
+	}
+	fmt.Fprintln(os.Stderr, "final groups\truns")
+	for _, b := range bins {
+		label := fmt.Sprint(b.low)
+		if b.high > b.low {
+			label = fmt.Sprintf("%d-%d", b.low, b.high)
+		}
+		bar := strings.Repeat("#", (b.runs*histogramWidth+most-1)/most)
+		fmt.Fprintf(os.Stderr, "%s\t%d\t%s\n", label, b.runs, bar)
+	}
+}
+
+func writeBins(name string, summaries []summary, width int) error {
+	// Write the histogram of final groups of each batch in summaries to the
+	// file name as CSV, a row for each bin.
+
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	out := bufio.NewWriter(f)
+	fmt.Fprintln(out, "size,vision,tolerance,final.blocks.low,final.blocks.high,runs")
+	for _, s := range summaries {
+		for _, b := range binCounts(s.groupCounts, width) {
+			fmt.Fprintf(out, "%d,%d,%f,%d,%d,%d\n", s.Size, s.Vision, s.Tolerance, b.low, b.high, b.runs)
+		}
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
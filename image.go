@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sdmccabe/schelling-go/schelling"
+)
+
+// defaultColors are the colors of agents of each type in -gif and -png
+// output, one for each of schelling.Glyphs.
+const defaultColors = "#e41a1c,#377eb8,#4daf4a,#984ea3,#ff7f00,#ffff33,#a65628,#f781bf"
+
+// emptyColor is the color of empty cells in -gif and -png output.
+var emptyColor = color.RGBA{0xff, 0xff, 0xff, 0xff}
+
+// stripHeight is the height in pixels of the image of a 1-D model.
+const stripHeight = 20
+
+func parseColors(s string) (color.Palette, error) {
+	// Parse a comma-separated list of colors written as #rrggbb into a
+	// palette whose first color is emptyColor, so that type t is color t+1.
+
+	palette := color.Palette{emptyColor}
+	for _, c := range strings.Split(s, ",") {
+		hex := strings.TrimPrefix(strings.TrimSpace(c), "#")
+		v, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil || len(hex) != 6 {
+			return nil, fmt.Errorf("color %q is not of the form #rrggbb", c)
+		}
+		palette = append(palette, color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff})
+	}
+	return palette, nil
+}
+
+func render(model *schelling.Model, palette color.Palette, scale int) *image.Paletted {
+	// Draw the model with each cell scale pixels wide: a strip of colored
+	// cells for a 1-D model, or the grid for a 2-D one.
+
+	cellHeight := scale
+	if model.Dim == 1 {
+		cellHeight = stripHeight
+	}
+	img := image.NewPaletted(image.Rect(0, 0, model.Width*scale, model.Height*cellHeight), palette)
+	for i := 0; i < model.Size; i++ {
+		x, y := i%model.Width*scale, i/model.Width*cellHeight
+		index := uint8(model.Agent(i) + 1) // Empty is -1, so it gets color 0
+		for dy := 0; dy < cellHeight; dy++ {
+			for dx := 0; dx < scale; dx++ {
+				img.SetColorIndex(x+dx, y+dy, index)
+			}
+		}
+	}
+	return img
+}
+
+func writePNG(filename string, model *schelling.Model, palette color.Palette) error {
+	// Write the model to filename as a PNG image, one pixel wide per cell.
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = png.Encode(f, render(model, palette, 1))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
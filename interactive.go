@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/sdmccabe/schelling-go/schelling"
+)
+
+// errQuit ends an interactive run at the user's request. The run is
+// abandoned, as if interrupted.
+var errQuit = errors.New("quit")
+
+const interactiveHelp = "Enter to step, a number to take that many steps, r to run to the end, q to quit"
+
+func runInteractive(ctx context.Context, model *schelling.Model, maxTicks int, in io.Reader) (int64, bool, error) {
+	// Equivalent to model.RunToEquilibriumContext, but advance only as the
+	// user asks on in, printing the model after every tick along with the
+	// number of unhappy agents.
+
+	lines := make(chan string)
+	go func() {
+		// read in the background, so an interrupt isn't held up by a read
+		defer close(lines)
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	show := func(ticks int64) {
+		fmt.Println(model)
+		fmt.Printf("tick %d, %d unhappy, %d moves\n", ticks, model.Unhappy(), model.Moves())
+	}
+
+	// prompt asks how many steps to take next, setting remaining, or returns
+	// the reason to stop instead
+	var remaining int
+	prompt := func() error {
+		for {
+			fmt.Print("> ")
+			var line string
+			var ok bool
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case line, ok = <-lines:
+			}
+			if !ok {
+				return errQuit // end of input
+			}
+
+			switch line = strings.TrimSpace(line); line {
+			case "":
+				remaining = 1
+			case "q":
+				return errQuit
+			case "r":
+				remaining = maxTicks // as many as it takes
+			default:
+				n, err := strconv.Atoi(line)
+				if err != nil || n <= 0 {
+					fmt.Println(interactiveHelp)
+					continue
+				}
+				remaining = n
+			}
+			return nil
+		}
+	}
+
+	fmt.Println(interactiveHelp)
+	show(1)
+	if model.Converged() {
+		fmt.Println("Model converged")
+		return 1, true, nil
+	}
+	if err := prompt(); err != nil {
+		return 1, false, err
+	}
+
+	// once the user stops, cancel the run, and let it run out the ticks
+	// until it notices without asking for more
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var stop error
+	onStep(model, func(ticks int64, m *schelling.Model) {
+		remaining--
+		if remaining > 0 || stop != nil || ticks > int64(maxTicks) || m.Converged() || m.Plateaued() {
+			return // the run goes on, or is over and is shown below
+		}
+		show(ticks)
+		if stop = prompt(); stop != nil {
+			cancel()
+		}
+	})
+	ticks, ok, err := model.RunToEquilibriumContext(ctx, maxTicks)
+	if stop != nil {
+		return ticks, false, stop
+	}
+	if err != nil {
+		return ticks, false, err
+	}
+	show(ticks)
+	if ok {
+		fmt.Println("Model converged")
+	} else {
+		fmt.Println("Model failed to stabilize")
+	}
+	return ticks, ok, nil
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Output formats for the per-run results written to the -o file. CSV is
+// the default; JSON writes an object holding an array of runs and a summary;
+// JSONL writes each run as a JSON object on a line of its own, without a
+// summary, so that every line is valid however the file ends.
+const (
+	formatCSV   = "csv"
+	formatJSON  = "json"
+	formatJSONL = "jsonl"
+)
+
+// summary holds the statistics reported at the end of a batch of runs with
+// the same size, vision and tolerance. The statistics of ticks cover only the runs
+// that reached equilibrium, and those of final groups, interfaces,
+// segregation and entropy only the runs whose final state was measured: the same runs, or
+// every run with stochastic moves.
+type summary struct {
+	Size                int       `json:"size"`
+	Vision              int       `json:"vision"`
+	Tolerance           float64   `json:"tolerance"`
+	ThresholdCount      int       `json:"thresholdCount,omitempty"`
+	Runs                int       `json:"runs"`
+	Successes           int       `json:"successes"`
+	Failures            int       `json:"failures"`
+	Cycling             int       `json:"cycling"`
+	GaveUp              int       `json:"gaveUp"` // failed runs in which an agent gave up moving
+	MeanTicks           jsonFloat `json:"meanTicks"`
+	SdTicks             jsonFloat `json:"sdTicks"`
+	P25Ticks            jsonFloat `json:"p25Ticks"`
+	MedianTicks         jsonFloat `json:"medianTicks"`
+	P75Ticks            jsonFloat `json:"p75Ticks"`
+	P95Ticks            jsonFloat `json:"p95Ticks"`
+	MeanInitGroups      jsonFloat `json:"meanInitGroups"`
+	SdInitGroups        jsonFloat `json:"sdInitGroups"`
+	MeanInitInterfaces  jsonFloat `json:"meanInitInterfaces"`
+	SdInitInterfaces    jsonFloat `json:"sdInitInterfaces"`
+	MeanFinalGroups     jsonFloat `json:"meanFinalGroups"`
+	SdFinalGroups       jsonFloat `json:"sdFinalGroups"`
+	MeanFinalInterfaces jsonFloat `json:"meanFinalInterfaces"`
+	SdFinalInterfaces   jsonFloat `json:"sdFinalInterfaces"`
+	MeanSegregation     jsonFloat `json:"meanSegregation"`
+	SdSegregation       jsonFloat `json:"sdSegregation"`
+	MeanEntropy         jsonFloat `json:"meanEntropy"`
+	SdEntropy           jsonFloat `json:"sdEntropy"`
+	Seconds             jsonFloat `json:"seconds"` // wall-clock time taken by the runs
+	RunsPerSecond       jsonFloat `json:"runsPerSecond"`
+	TicksPerSecond      jsonFloat `json:"ticksPerSecond"`
+
+	groupCounts map[int64]int // number of runs ending with each number of final groups
+}
+
+// jsonFloat is a float64 that marshals NaN and infinities, such as the
+// standard deviation of a single run, as null rather than failing.
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	if math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+		return []byte("null"), nil
+	}
+	return json.Marshal(float64(f))
+}
+
+func (r modelRun) columns(tag string) (names []string, values []reflect.Value) {
+	// Return the names and values of the fields of r that have the given tag.
+
+	v := reflect.ValueOf(r)
+	for i := 0; i < v.NumField(); i++ {
+		if name, ok := v.Type().Field(i).Tag.Lookup(tag); ok {
+			names = append(names, name)
+			values = append(values, v.Field(i))
+		}
+	}
+	return names, values
+}
+
+func csvHeader() string {
+	names, _ := modelRun{}.columns("csv")
+	return csvLine(names)
+}
+
+func (r modelRun) String() string {
+	// Format the run as a CSV row, in the same column order as csvHeader.
+
+	_, values := r.columns("csv")
+	row := make([]string, len(values))
+	for i, v := range values {
+		switch v.Kind() {
+		case reflect.Float64:
+			row[i] = strconv.FormatFloat(v.Float(), 'f', 6, 64)
+		case reflect.String:
+			row[i] = v.String()
+		case reflect.Bool:
+			row[i] = strconv.FormatBool(v.Bool())
+		default:
+			row[i] = strconv.FormatInt(v.Int(), 10)
+		}
+	}
+	return csvLine(row)
+}
+
+func csvLine(fields []string) string {
+	// Join fields into a line of CSV, without the line ending, quoting any
+	// field holding a comma, such as a tolerance distribution's parameters,
+	// a quote or a line break.
+
+	var buffer bytes.Buffer
+	out := csv.NewWriter(&buffer)
+	out.Write(fields) // writing to a bytes.Buffer cannot fail
+	out.Flush()
+	return strings.TrimSuffix(buffer.String(), "\n")
+}
+
+func (r modelRun) MarshalJSON() ([]byte, error) {
+	// Marshal the run as a JSON object, with its fields in declaration order.
+
+	names, values := r.columns("jsonname")
+	var buffer bytes.Buffer
+	buffer.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		var value interface{}
+		switch v.Kind() {
+		case reflect.Float64:
+			value = jsonFloat(v.Float())
+		case reflect.String:
+			value = v.String()
+		case reflect.Bool:
+			value = v.Bool()
+		default:
+			value = v.Int()
+		}
+		b, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buffer, "%q:%s", names[i], b)
+	}
+	buffer.WriteByte('}')
+	return buffer.Bytes(), nil
+}
+
+func writeHeader() error {
+	// Write whatever precedes the first run to the output file.
+
+	var err error
+	switch format {
+	case formatJSON:
+		_, err = w.WriteString(`{"runs":[`)
+	case formatJSONL:
+	default:
+		_, err = w.WriteString(csvHeader() + "\n")
+	}
+	return err
+}
+
+func openForAppend(name string) (f *os.File, hasHeader bool, nextRun int, err error) {
+	// Open the file name for adding runs to its end, creating it if it
+	// doesn't exist, and report whether it already holds a CSV header, and
+	// the number to give the first run added: one more than the highest run
+	// number in the file, so that runs keep distinct numbers however many
+	// times a file is appended to. A header with other columns than this
+	// version writes is an error, since the rows added would not line up
+	// with it. JSONL has no header.
+
+	f, err = os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, false, 0, err
+	}
+	if format == formatJSONL {
+		nextRun, err = nextRunJSONL(f)
+	} else {
+		hasHeader, nextRun, err = nextRunCSV(f)
+	}
+	if err != nil {
+		f.Close()
+		return nil, false, 0, fmt.Errorf("%s: %v", name, err)
+	}
+	return f, hasHeader, nextRun, nil
+}
+
+func nextRunCSV(r io.Reader) (hasHeader bool, nextRun int, err error) {
+	// Read the CSV runs from r, checking their header, and return whether
+	// there is one and one more than the highest run number.
+
+	in := csv.NewReader(r)
+	header, err := in.Read()
+	if err == io.EOF {
+		return false, 0, nil // new or empty
+	}
+	if err != nil {
+		return false, 0, err
+	}
+	if csvLine(header) != csvHeader() {
+		return false, 0, errors.New("its columns differ from those this version writes")
+	}
+	for {
+		row, err := in.Read()
+		if err == io.EOF {
+			return true, nextRun, nil
+		}
+		if err != nil {
+			return false, 0, err
+		}
+		run, err := strconv.Atoi(row[0]) // the run column comes first
+		if err != nil {
+			return false, 0, fmt.Errorf("run number %q: %v", row[0], err)
+		}
+		nextRun = max(nextRun, run+1)
+	}
+}
+
+func nextRunJSONL(r io.Reader) (nextRun int, err error) {
+	// Read the JSONL runs from r and return one more than the highest run
+	// number.
+
+	in := json.NewDecoder(r)
+	for {
+		var run struct {
+			Run int `json:"run"`
+		}
+		if err := in.Decode(&run); err == io.EOF {
+			return nextRun, nil
+		} else if err != nil {
+			return 0, err
+		}
+		nextRun = max(nextRun, run.Run+1)
+	}
+}
+
+// how often the output file is flushed while runs are still going, so that a
+// crash or kill loses at most this much work
+const flushInterval = time.Second
+
+var lastFlush time.Time
+
+var written int // runs written to the output file so far
+
+func writeRun(r modelRun) error {
+	// Write one run to the output file. Runs are never split across flushes, so if the program dies the CSV
+	// on disk is truncated at the end of a row.
+
+	var b []byte
+	var err error
+	switch format {
+	case formatJSON:
+		if b, err = json.Marshal(r); err != nil {
+			return err
+		}
+		if written > 0 {
+			b = append([]byte{','}, b...)
+		}
+	case formatJSONL:
+		if b, err = json.Marshal(r); err != nil {
+			return err
+		}
+		b = append(b, '\n')
+	default:
+		b = []byte(fmt.Sprintln(r))
+	}
+
+	if w.Available() < len(b) {
+		if err = w.Flush(); err != nil {
+			return err
+		}
+	}
+	if _, err = w.Write(b); err != nil {
+		return err
+	}
+	written++
+	if time.Since(lastFlush) >= flushInterval {
+		lastFlush = time.Now()
+		return w.Flush()
+	}
+	return nil
+}
+
+func writeFooter(summaries []summary) error {
+	// Write whatever follows the last run to the output file. A sweep over
+	// several parameter combinations has a list of summaries, one for each.
+
+	if format != formatJSON {
+		return nil
+	}
+	var b []byte
+	var err error
+	key := "summary"
+	if len(summaries) == 1 {
+		b, err = json.Marshal(summaries[0])
+	} else {
+		key = "summaries"
+		b, err = json.Marshal(summaries)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, `],%q:%s}`+"\n", key, b)
+	return err
+}
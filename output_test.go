@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/csv"
+	"strings"
+	"testing"
+)
+
+func TestCSVColumns(t *testing.T) {
+	// The header and a row must have the same number of columns, even when
+	// a field holds a comma.
+
+	header, err := csv.NewReader(strings.NewReader(csvHeader())).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := modelRun{size: 100, vision: 2, tolerance: 0.5, distrib: "uniform:0.3,0.7", status: statusConverged}
+	row, err := csv.NewReader(strings.NewReader(r.String())).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(row) != len(header) {
+		t.Fatalf("header has %d columns, but a row has %d", len(header), len(row))
+	}
+	for i, name := range header {
+		if name == "tolerance.dist" && row[i] != r.distrib {
+			t.Errorf("tolerance.dist = %q, want %q", row[i], r.distrib)
+		}
+	}
+}
+
+func TestNextRun(t *testing.T) {
+	// Appended runs are numbered on from the highest run number in the
+	// file, or from 0 in an empty one.
+
+	row := func(run int) string { return modelRun{runNumber: run}.String() + "\n" }
+	for _, tt := range []struct {
+		name   string
+		csv    string
+		header bool
+		want   int
+	}{
+		{"empty", "", false, 0},
+		{"header only", csvHeader() + "\n", true, 0},
+		{"runs", csvHeader() + "\n" + row(0) + row(1) + row(2), true, 3},
+		{"runs out of order", csvHeader() + "\n" + row(4) + row(9) + row(5), true, 10},
+	} {
+		header, next, err := nextRunCSV(strings.NewReader(tt.csv))
+		if err != nil || header != tt.header || next != tt.want {
+			t.Errorf("%s: got %v, %d, %v, want %v, %d", tt.name, header, next, err, tt.header, tt.want)
+		}
+	}
+	if _, _, err := nextRunCSV(strings.NewReader("run,size\n0,100\n")); err == nil {
+		t.Error("other columns: no error")
+	}
+
+	next, err := nextRunJSONL(strings.NewReader(`{"run":0,"size":100}` + "\n" + `{"run":7,"size":100}` + "\n"))
+	if err != nil || next != 8 {
+		t.Errorf("jsonl: got %d, %v, want 8", next, err)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// progress reports on standard error how many of the runs of a sweep have
+// finished. It is only used by the goroutine that records finished runs,
+// so it needs no locking. A nil progress reports nothing.
+type progress struct {
+	total   int
+	done    int
+	every   time.Duration // least time between reports
+	inPlace bool          // redraw a single line, on a terminal
+	shown   bool          // whether a line is on the screen to be cleared
+	last    time.Time
+}
+
+func newProgress(total int) *progress {
+	// Return a progress for total runs, redrawing a single line several
+	// times a second if standard error is a terminal, or else printing a
+	// line every few seconds.
+
+	p := &progress{total: total, every: 5 * time.Second, last: time.Now()}
+	if info, err := os.Stderr.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		p.inPlace = true
+		p.every = 200 * time.Millisecond
+	}
+	return p
+}
+
+func (p *progress) add() {
+	// Count a finished run, and report if it's time to.
+
+	if p == nil {
+		return
+	}
+	p.done++
+	if time.Since(p.last) < p.every {
+		return
+	}
+	p.last = time.Now()
+	line := fmt.Sprintf("completed %d/%d runs (%.0f%%)", p.done, p.total, 100*float64(p.done)/float64(p.total))
+	if p.inPlace {
+		fmt.Fprint(os.Stderr, "\r"+line)
+		p.shown = true
+	} else {
+		fmt.Fprintln(os.Stderr, line)
+	}
+}
+
+func (p *progress) clear() {
+	// Erase the progress line, if there is one, so other messages can be printed.
+
+	if p == nil || !p.shown {
+		return
+	}
+	fmt.Fprint(os.Stderr, "\r\033[K")
+	p.shown = false
+}
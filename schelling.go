@@ -19,369 +19,1038 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"flag"
 	"fmt"
-	"github.com/grd/stat"
 	"github.com/pkg/profile"
+	"github.com/sdmccabe/schelling-go/schelling"
+	"image/color"
+	"io"
 	"log"
+	"log/slog"
+	"math"
 	"math/rand"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
-	"sync"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 )
 
 // declare data types
+
+// modelRun records the outcome of one run. The csv and jsonname tags name
+// each field's column in CSV output and key in JSON output; fields without
+// them are not written.
 type modelRun struct {
-	runNumber   int
-	size        int
-	vision      int
-	tolerance   float64
-	initGroups  int64
-	finalGroups int64
-	ticks       int64
+	runNumber       int           `csv:"run" jsonname:"run"`
+	size            int           `csv:"size" jsonname:"size"`
+	dim             int           `csv:"dim" jsonname:"dim"`
+	topology        string        `csv:"topology" jsonname:"topology"`
+	width           int           `csv:"width" jsonname:"width"`
+	height          int           `csv:"height" jsonname:"height"`
+	groups          int           `csv:"groups" jsonname:"groups"`
+	density         float64       `csv:"density" jsonname:"density"`
+	ratio           float64       `csv:"ratio" jsonname:"ratio"` // fraction of the agents of type 1
+	vision          int           `csv:"vision" jsonname:"vision"`
+	visionLeft      int           `csv:"vision.left" jsonname:"visionLeft"`
+	visionRight     int           `csv:"vision.right" jsonname:"visionRight"`
+	tolerance       float64       `csv:"tolerance" jsonname:"tolerance"`
+	thresholdCount  int           `csv:"threshold.count" jsonname:"thresholdCount"`
+	convergeEps     float64       `csv:"converge.eps" jsonname:"convergeEps"`
+	strict          bool          `csv:"strict" jsonname:"strict"`
+	weights         string        `csv:"weights" jsonname:"weights"`
+	neighborhood    string        `csv:"neighborhood" jsonname:"neighborhood"` // empty on a ring or line
+	movement        string        `csv:"movement" jsonname:"movement"`
+	activation      string        `csv:"activation" jsonname:"activation"`
+	moveAttempts    int           `csv:"move.attempts" jsonname:"moveAttempts"`
+	beta            float64       `csv:"move.prob" jsonname:"moveProb"`
+	anchored        float64       `csv:"anchored" jsonname:"anchored"`
+	tolerance0      float64       `csv:"tolerance0" jsonname:"tolerance0"`
+	tolerance1      float64       `csv:"tolerance1" jsonname:"tolerance1"`
+	distrib         string        `csv:"tolerance.dist" jsonname:"toleranceDist"`
+	initClusters    int           `csv:"init.clusters" jsonname:"initClusters"`
+	initGroups      int64         `csv:"init.blocks" jsonname:"initGroups"`
+	finalGroups     int64         `csv:"final.blocks" jsonname:"finalGroups"`
+	initInterfaces  float64       `csv:"init.interfaces" jsonname:"initInterfaces"`
+	finalInterfaces float64       `csv:"final.interfaces" jsonname:"finalInterfaces"`
+	minCluster      int           `csv:"cluster.min" jsonname:"minClusterSize"`
+	maxCluster      int           `csv:"cluster.max" jsonname:"maxClusterSize"`
+	meanCluster     float64       `csv:"cluster.mean" jsonname:"meanClusterSize"`
+	segregation     float64       `csv:"segregation" jsonname:"segregation"`
+	entropy         float64       `csv:"entropy" jsonname:"entropy"`
+	finalUnhappy    float64       `csv:"final.unhappy" jsonname:"finalUnhappy"`     // fraction of the agents unhappy at the end
+	maxUnhappy      float64       `csv:"max.unhappy" jsonname:"maxUnhappyFraction"` // largest such fraction during the run
+	firewallSize    int           `csv:"firewall.size" jsonname:"firewallSize"`
+	firstFirewall   int64         `csv:"first.firewall.tick" jsonname:"firstFirewallTick"`
+	status          string        `csv:"status" jsonname:"status"`
+	ticks           int64         `csv:"ticks" jsonname:"ticks"`
+	moves           int64         `csv:"moves" jsonname:"moves"`
+	gaveUp          int64         `csv:"gave.up" jsonname:"gaveUp"`
+	elapsed         time.Duration `csv:"elapsed.ns" jsonname:"elapsedNs"`
+	seed            int64         `csv:"seed" jsonname:"seed"`
+
+	clusterSizes []int // sizes of the final groups
+	ticksRun     int64 // ticks simulated, whether or not the run converged
 }
 
 type modelRuns []modelRun
-type model []int
 
-func (r modelRun) String() string {
-	return fmt.Sprintf("%d,%d,%d,%f,%d,%d,%d", r.runNumber, r.size, r.vision, r.tolerance, r.initGroups, r.finalGroups, r.ticks)
-}
+// Outcomes of a run, recorded as its status.
+const (
+	statusConverged = "converged"
+	statusCapped    = "capped"  // stopped at the tick cap while still making progress
+	statusCycling   = "cycling" // stopped at the tick cap, having made no progress for a long time
+	statusPlateau   = "plateau" // stopped early by -plateau-ticks, having made too little progress
+)
 
-func (m model) String() string {
-	var buffer bytes.Buffer
+// samplerSeedMask is XORed into a run's seed to seed the generator that picks
+// the ticks -snapshot-count samples.
+const samplerSeedMask = 0x5deece66d
 
-	for _, x := range m {
-		if x == 0 {
-			buffer.WriteString("X")
-		} else if x == 1 {
-			buffer.WriteString("O")
-		} else {
-			fmt.Println("Error: Unexpected model element")
-			os.Exit(1)
-		}
-	}
+// settings controls how a batch of runs is carried out, as opposed to the
+// model parameters in schelling.Config.
+type settings struct {
+	verbose       bool          // print the model after every tick
+	animate       bool          // redraw the model in place after every tick
+	interactive   bool          // step the model as the user asks on standard input
+	fps           int           // frames per second, if animate
+	gifFile       string        // file to write an animated GIF of the run to, if any
+	gifEvery      int           // ticks between frames of the GIF
+	pngFile       string        // file to write an image of the final model to, if any
+	snapshotFile  string        // file to append snapshots of the run to, if any
+	snapshotEvery int           // ticks between snapshots
+	snapshotCount int           // if set, sample this many snapshots from the whole run instead
+	firewallSize  int           // if set, find when a block of this many agents forms for good
+	saveFile      string        // file to write the final model to, if any
+	dumpDir       string        // directory to write the final model of every run to, if any
+	dumpGzip      bool          // compress the files written to dumpDir
+	palette       color.Palette // colors of empty cells and each type in images
+	parallel      bool          // do runs concurrently on a pool of workers
+	numWorkers    int           // number of workers, if parallel
+	seed          int64         // base seed from which each run's seed is drawn
+	runSeed       int64         // seed of the single run to do, if runSeedSet
+	runSeedSet    bool
+	histogram     bool         // print the distribution of final group sizes
+	groupsHist    bool         // print the distribution of the number of final groups
+	groupsBin     int          // width of the bins of that distribution
+	groupsFile    string       // file to write that distribution to, if any
+	percentiles   bool         // keep every run's ticks to report percentiles
+	maxTicks      int          // ticks after which a run is abandoned
+	perCell       bool         // whether maxTicks is per cell of the model
+	sizeSweep     bool         // whether the batch covers several model sizes
+	quiet         bool         // print nothing but errors and the output asked for
+	progress      *progress    // reports runs finished so far, if not nil
+	logger        *slog.Logger // structured log for verbose output, if -log-format is set
+}
 
-	return buffer.String()
+// profileTypes maps the values of -profile-type to the kind of profile to take.
+var profileTypes = map[string]func(*profile.Profile){
+	"cpu":   profile.CPUProfile,
+	"mem":   profile.MemProfile,
+	"block": profile.BlockProfile,
+	"mutex": profile.MutexProfile,
 }
 
 // declare global variables
-var profileRun bool
 var w *bufio.Writer
-var verbose bool
-var writeToFile bool
-var vision int
-var tolerance float64
+var writeResults bool // to the -o file, or else standard output
 var filename string
-var parallel bool
-var numChunks int
-
-func aggregateRuns(numRuns, size, vision int, tolerance float64, verbose bool) {
-	// Set up environment, perform the desired number of runs,
-	// and output summary statistics
-
-	// set up measurement variables
-	successes := 0
-	times := make(stat.IntSlice, 0)       //only used for stat
-	initGroups := make(stat.IntSlice, 0)  //only used for stat
-	finalGroups := make(stat.IntSlice, 0) //only used for stat
-
-	// numChunks := runtime.NumCPU() * 2
-	if !parallel {
-		numChunks = 1 //avoid compiler warning
-	}
-	chunkSize := numRuns / numChunks
-	results := make(chan modelRun, numChunks+1)
-
-	if writeToFile {
-		f, err := os.Create(filename)
-		defer f.Close()
-		w = bufio.NewWriter(f)
-		defer w.Flush()
+var appendOutput bool // add runs to the end of an existing -o file
+var format string
 
-		//TODO: Writing csv headers is very fragile, see if this can be improved.
-		_, err = w.WriteString("run,size,vision,tolerance,init.blocks,final.blocks,ticks\n")
-		if err != nil {
-			log.Fatal(err)
+func aggregateRuns(ctx context.Context, numRuns int, cfg schelling.Config, set settings, firstRun int) summary {
+	// Perform the desired number of runs, numbering them from firstRun,
+	// and output summary statistics. If ctx is cancelled, runs in progress
+	// are abandoned and the statistics cover the runs that completed.
+
+	// set up measurement variables; ticks and times only cover the runs that
+	// reached equilibrium, and finalGroups, finalInterfaces, segregation and
+	// entropy those whose final state was measured: the same runs, or with
+	// stochastic moves, every run
+	runs, successes, cycling := 0, 0, 0
+	gaveUp := 0        // failed runs in which an agent gave up moving
+	var ticksRun int64 // for throughput
+	start := time.Now()
+	var ticks, initGroups, finalGroups, segregation, entropy running
+	var initInterfaces, finalInterfaces running
+	var times []int64                  // kept only for percentiles, which need every value
+	clusterSizes := make(map[int]int)  // number of final groups of each size
+	groupCounts := make(map[int64]int) // number of runs ending with each number of groups
+
+	// record a finished run in the measurement variables and the output file
+	record := func(result modelRun) {
+		if writeResults {
+			if err := writeRun(result); err != nil {
+				log.Fatal(err)
+			}
 		}
-	}
-	if parallel {
-		go func() {
-			for {
-				result := <-results
-				if result.ticks != -1 {
-					successes++
-				}
+		runs++
+		set.progress.add()
+		ticksRun += result.ticksRun
+		initGroups.add(float64(result.initGroups))
+		initInterfaces.add(result.initInterfaces)
+		if result.status == statusConverged {
+			successes++
+			ticks.add(float64(result.ticks))
+			if set.percentiles {
 				times = append(times, result.ticks)
-				initGroups = append(initGroups, result.initGroups)
-				finalGroups = append(finalGroups, result.finalGroups)
-				if writeToFile {
-					w.WriteString(fmt.Sprintln(result))
-				}
 			}
-		}()
-	}
-	var wg sync.WaitGroup
-	if parallel {
-		wg.Add(numChunks)
-		for i := 0; i < numChunks; i++ {
-			source := rand.NewSource(time.Now().UnixNano() - int64(20*i)) //feeble effort to keep two goroutines from using the same seed
-			generator := rand.New(source)
-			go func(n, s int, g *rand.Rand) {
-				for j := 0; j < n; j++ {
-					results <- runModel(s, g)
-				}
-				wg.Done()
-			}(chunkSize, size, generator)
+		} else if result.status == statusCycling || result.status == statusPlateau {
+			cycling++
 		}
-
-		wg.Wait() // wait for all model runs to end before computing statistics
-	} else {
-		source := rand.NewSource(time.Now().UnixNano())
-		generator := rand.New(source)
-
-		serialResults := make([]modelRun, numRuns)
-		for i := 0; i < numRuns; i++ {
-			serialResults[i] = runModel(size, generator)
-		}
-		// populating IntSlices for statistics
-		for i := 0; i < len(serialResults); i++ {
-			times = append(times, serialResults[i].ticks)
-			if times[i] != -1 {
-				successes++
+		if result.status != statusConverged && result.gaveUp > 0 {
+			gaveUp++
+		}
+		if result.finalGroups >= 0 { // the final state was measured
+			finalGroups.add(float64(result.finalGroups))
+			groupCounts[result.finalGroups]++
+			finalInterfaces.add(result.finalInterfaces)
+			segregation.add(result.segregation)
+			entropy.add(result.entropy)
+			for _, size := range result.clusterSizes {
+				clusterSizes[size]++
 			}
-			initGroups = append(initGroups, serialResults[i].initGroups)
-			finalGroups = append(finalGroups, serialResults[i].finalGroups)
 		}
-
 	}
 
-	// output statistics to console
-	fmt.Println("Summary statistics:")
-	fmt.Printf("%d runs reach equilibrium (%.1f%%) in %.1f ticks (s.d.: %.1f)\n", successes,
-		100*float64(successes)/float64(numRuns), stat.Mean(times), stat.Sd(times))
-	fmt.Printf("%.1f average initial groups (s.d.: %.1f)\n", stat.Mean(initGroups), stat.Sd(initGroups))
-	fmt.Printf("%.1f average final groups (s.d.: %.1f)\n", stat.Mean(finalGroups), stat.Sd(finalGroups))
-}
-
-func runModel(size int, generator *rand.Rand) modelRun {
-	// Execute one run of the model. Return true if the model converged.
-
-	// model setup
-	model := setup(size, generator)
-	r := modelRun{
-		size:        size,
-		vision:      vision,
-		tolerance:   tolerance,
-		initGroups:  countDistinct(model),
-		finalGroups: -1,
-		ticks:       -1}
-
-	ticks := int64(1)
-	if verbose {
-		fmt.Printf("Run number %d\n", r.runNumber)
-		fmt.Printf("%d distinct groups at start\n", r.initGroups)
-		fmt.Println(model)
+	// each run has its own generator, seeded with a seed drawn from the
+	// base seed in order of run number, so that a run's outcome depends
+	// neither on which worker does it nor on whether runs are parallel, and
+	// any run can be done again on its own with -run-seed. every
+	// combination of a sweep draws the same seeds, so the ith run of each
+	// starts from the same model and differs only in its parameters
+	numWorkers := 1 // serial runs are a pool of one
+	if set.parallel {
+		numWorkers = set.numWorkers
 	}
+	results := schelling.Runs(ctx, numRuns, numWorkers, set.seed, func(ctx context.Context, number int, seed int64) (modelRun, error) {
+		if set.runSeedSet {
+			seed = set.runSeed
+		}
+		return runModel(ctx, schelling.New(cfg, rand.New(rand.NewSource(seed))), cfg, set, firstRun+number, seed)
+	})
 
-	// model run
-	for !isConverged(model) {
-		step(model, generator)
-		ticks++
-		if verbose {
-			fmt.Println(model)
-		}
-		if int64(ticks) > int64(500*len(model)) { // arbitary number to avoid infinite loops
-			if verbose {
-				fmt.Println("Model failed to stabilize")
-			}
-			ticks = -1
-			break
+	// record runs in order of run number, whatever order they finish in, so
+	// that the output of a seeded batch is always the same
+	pending := make(map[int]modelRun) // runs finished ahead of their turn
+	next := firstRun
+	for result := range results {
+		pending[result.runNumber] = result
+		for r, ok := pending[next]; ok; r, ok = pending[next] {
+			delete(pending, next)
+			record(r)
+			next++
 		}
 	}
+	// if the batch was cancelled, some runs never finished; record the ones
+	// after the gap in order too
+	rest := make([]int, 0, len(pending))
+	for runNumber := range pending {
+		rest = append(rest, runNumber)
+	}
+	sort.Ints(rest)
+	for _, runNumber := range rest {
+		record(pending[runNumber])
+	}
 
-	success := isConverged(model)
-	if success {
-		r.finalGroups = countDistinct(model)
-		if verbose {
-			//fmt.Println(model)
-			fmt.Printf("%d distinct groups at end after %d moves\n", r.finalGroups, ticks)
-			fmt.Println()
+	elapsed := time.Since(start)
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] }) // for percentiles
+
+	s := summary{
+		Size:                cfg.Size,
+		Vision:              cfg.Vision,
+		Tolerance:           cfg.Tolerance,
+		ThresholdCount:      cfg.ThresholdCount,
+		Runs:                runs,
+		Successes:           successes,
+		Failures:            runs - successes,
+		Cycling:             cycling,
+		GaveUp:              gaveUp,
+		MeanTicks:           jsonFloat(ticks.Mean()),
+		SdTicks:             jsonFloat(ticks.Sd()),
+		P25Ticks:            jsonFloat(percentile(times, 25)),
+		MedianTicks:         jsonFloat(percentile(times, 50)),
+		P75Ticks:            jsonFloat(percentile(times, 75)),
+		P95Ticks:            jsonFloat(percentile(times, 95)),
+		MeanInitGroups:      jsonFloat(initGroups.Mean()),
+		SdInitGroups:        jsonFloat(initGroups.Sd()),
+		MeanInitInterfaces:  jsonFloat(initInterfaces.Mean()),
+		SdInitInterfaces:    jsonFloat(initInterfaces.Sd()),
+		MeanFinalGroups:     jsonFloat(finalGroups.Mean()),
+		SdFinalGroups:       jsonFloat(finalGroups.Sd()),
+		MeanFinalInterfaces: jsonFloat(finalInterfaces.Mean()),
+		SdFinalInterfaces:   jsonFloat(finalInterfaces.Sd()),
+		MeanSegregation:     jsonFloat(segregation.Mean()),
+		SdSegregation:       jsonFloat(segregation.Sd()),
+		MeanEntropy:         jsonFloat(entropy.Mean()),
+		SdEntropy:           jsonFloat(entropy.Sd()),
+		Seconds:             jsonFloat(elapsed.Seconds()),
+		RunsPerSecond:       jsonFloat(float64(runs) / elapsed.Seconds()),
+		TicksPerSecond:      jsonFloat(float64(ticksRun) / elapsed.Seconds()),
+		groupCounts:         groupCounts,
+	}
+	// output statistics to console
+	set.progress.clear()
+	if ctx.Err() != nil {
+		fmt.Fprintf(os.Stderr, "Stopped early (%v) after %d of %d runs\n", ctx.Err(), runs, numRuns)
+	}
+	if runs == 0 {
+		return s
+	}
+	if !set.quiet {
+		printSummary(s, set.percentiles, set.sizeSweep)
+	}
+	if set.histogram && len(clusterSizes) > 0 {
+		sizes := make([]int, 0, len(clusterSizes))
+		for size := range clusterSizes {
+			sizes = append(sizes, size)
+		}
+		sort.Ints(sizes)
+		fmt.Fprintln(os.Stderr, "final group size\tnumber of groups")
+		for _, size := range sizes {
+			fmt.Fprintf(os.Stderr, "%d\t%d\n", size, clusterSizes[size])
 		}
-		r.ticks = ticks
 	}
-
-	return r
+	if set.groupsHist && len(groupCounts) > 0 {
+		printBins(binCounts(groupCounts, set.groupsBin))
+	}
+	return s
 }
 
-func countDistinct(model model) int64 {
-	// Identify coherent subpopulations, what Brandt et al call "firewalls."
-
-	val := model[0]
-	x := int64(0)
+func printSummary(s summary, percentiles, showSize bool) {
+	// Print the summary statistics of a batch of runs for people to read,
+	// naming the number of agents if showSize.
 
-	for _, element := range model {
-		if val != element {
-			val = element
-			x++
+	fmt.Fprint(os.Stderr, "Summary statistics for ")
+	if showSize {
+		fmt.Fprintf(os.Stderr, "%d agents, ", s.Size)
+	}
+	if s.ThresholdCount > 0 {
+		fmt.Fprintf(os.Stderr, "vision %d and threshold count %d:\n", s.Vision, s.ThresholdCount)
+	} else {
+		fmt.Fprintf(os.Stderr, "vision %d and tolerance %g:\n", s.Vision, s.Tolerance)
+	}
+	if s.Successes > 0 {
+		fmt.Fprintf(os.Stderr, "%d runs reach equilibrium (%.1f%%) in %.1f ticks (s.d.: %.1f)\n", s.Successes,
+			100*float64(s.Successes)/float64(s.Runs), s.MeanTicks, s.SdTicks)
+		if percentiles {
+			fmt.Fprintf(os.Stderr, "ticks to equilibrium: 25th percentile %.1f, median %.1f, 75th percentile %.1f, 95th percentile %.1f\n",
+				s.P25Ticks, s.MedianTicks, s.P75Ticks, s.P95Ticks)
 		}
+	} else {
+		fmt.Fprintln(os.Stderr, "0 runs reach equilibrium (0.0%)")
 	}
-
-	if model[0] != model[len(model)-1] { // wrap around
-		x++
+	fmt.Fprintf(os.Stderr, "%d runs fail to reach equilibrium (%.1f%%), %d of which stopped making progress\n", s.Failures,
+		100*float64(s.Failures)/float64(s.Runs), s.Cycling)
+	if s.GaveUp > 0 {
+		fmt.Fprintf(os.Stderr, "%d failed runs had agents give up after -move-attempts tries without finding a place; more attempts may let them converge\n", s.GaveUp)
 	}
-
-	return x
+	fmt.Fprintf(os.Stderr, "%.1f average initial groups (s.d.: %.1f)\n", s.MeanInitGroups, s.SdInitGroups)
+	fmt.Fprintf(os.Stderr, "%.3f average initial interface density (s.d.: %.3f)\n", s.MeanInitInterfaces, s.SdInitInterfaces)
+	if !math.IsNaN(float64(s.MeanFinalGroups)) {
+		fmt.Fprintf(os.Stderr, "%.1f average final groups (s.d.: %.1f)\n", s.MeanFinalGroups, s.SdFinalGroups)
+		fmt.Fprintf(os.Stderr, "%.3f average final interface density (s.d.: %.3f)\n", s.MeanFinalInterfaces, s.SdFinalInterfaces)
+		fmt.Fprintf(os.Stderr, "%.3f average segregation (s.d.: %.3f)\n", s.MeanSegregation, s.SdSegregation)
+		fmt.Fprintf(os.Stderr, "%.3f average mixing entropy (s.d.: %.3f)\n", s.MeanEntropy, s.SdEntropy)
+	}
+	fmt.Fprintf(os.Stderr, "%d runs in %.2fs: %.1f runs per second, %.0f ticks per second\n", s.Runs, s.Seconds, s.RunsPerSecond, s.TicksPerSecond)
 }
 
-func setup(size int, generator *rand.Rand) model {
-	// Return an initialized 1-D Schelling model, a slice of ints limited
-	// to the range [0, 1] of an arbitary size.
+func printScaling(summaries []summary) {
+	// Print a table of how the ticks to equilibrium grow with the number of
+	// agents, a row for each combination of parameters of a size sweep.
 
-	m := make(model, size)
-	for i := range m {
-		m[i] = generator.Intn(2)
+	fmt.Fprintln(os.Stderr, "size\tvision\ttolerance\truns\tconverged\tmean ticks\ts.d. ticks\tseconds")
+	for _, s := range summaries {
+		fmt.Fprintf(os.Stderr, "%d\t%d\t%g\t%d\t%d\t%.1f\t%.1f\t%.2f\n", s.Size, s.Vision, s.Tolerance,
+			s.Runs, s.Successes, s.MeanTicks, s.SdTicks, s.Seconds)
 	}
-	return m
 }
 
-func isConverged(model model) bool {
-	// Return true if all agents in the model are happy, else return false.
+func runModel(ctx context.Context, model *schelling.Model, cfg schelling.Config, set settings, runNumber int, seed int64) (modelRun, error) {
+	// Execute one run of model, newly set up from cfg or from the state an
+	// earlier run ended in, and record the outcome along with the seed of
+	// the run's generator. Return an error, and no outcome, if ctx is
+	// cancelled before the run ends.
 
-	for idx := range model {
-		if !isHappy(model, idx) {
-			return false
-		}
-	}
+	r := modelRun{
+		runNumber:       runNumber,
+		size:            model.Size,
+		dim:             model.Dim,
+		topology:        model.Topology,
+		width:           model.Width,
+		height:          model.Height,
+		groups:          model.Groups,
+		density:         model.Density,
+		ratio:           fractionOf(1, model.Counts()),
+		vision:          cfg.Vision,
+		visionLeft:      model.VisionLeft,
+		visionRight:     model.VisionRight,
+		tolerance:       cfg.Tolerance,
+		thresholdCount:  cfg.ThresholdCount,
+		convergeEps:     model.ConvergeEps,
+		strict:          cfg.Strict,
+		weights:         model.Weights,
+		neighborhood:    model.Neighborhood,
+		movement:        model.Movement,
+		activation:      model.Activation,
+		moveAttempts:    model.MoveAttempts,
+		beta:            model.Beta,
+		anchored:        model.Anchored,
+		tolerance0:      cfg.GroupTolerance(0),
+		tolerance1:      cfg.GroupTolerance(1),
+		distrib:         cfg.ToleranceDist.String(),
+		initClusters:    cfg.InitClusters,
+		initGroups:      model.CountDistinct(),
+		initInterfaces:  model.Interfaces(),
+		finalInterfaces: -1,
+		finalGroups:     -1,
+		minCluster:      -1,
+		maxCluster:      -1,
+		meanCluster:     -1,
+		segregation:     -1,
+		entropy:         -1,
+		firewallSize:    set.firewallSize,
+		firstFirewall:   -1,
+		status:          statusCapped,
+		ticks:           -1,
+		seed:            seed}
 
-	return true
-}
+	maxTicks := set.maxTicks // to avoid infinite loops
+	if set.perCell {
+		maxTicks *= model.Size
+	}
 
-func isHappy(model model, idx int) bool {
-	// Return true if the proportion of nearby agents of the same type is greater than or equal to
-	// its tolerance threshold. The number of agents examined is given by the vision global variable.
+	var trace tracer
+	if set.logger != nil {
+		trace = newSlogTracer(set.logger, runNumber)
+	} else if set.parallel {
+		// collect the run's verbose output and print it all at once when
+		// the run ends, so that it isn't interleaved with other runs'
+		var buffer bytes.Buffer
+		trace = plainTracer{out: &buffer}
+		defer printTagged(&buffer, fmt.Sprintf("run %d: ", runNumber))
+	} else {
+		trace = plainTracer{out: os.Stdout}
+	}
+	if set.verbose {
+		trace.start(r, model)
+	}
 
-	count := 0
-	for x := 1; x <= vision; x++ {
-		y := (idx - x) % len(model)
-		if y < 0 {
-			y += len(model)
+	// model run
+	started := time.Now()
+	var ticks int64
+	var success bool
+	var err error
+	if set.verbose {
+		ticks, success, err = runVerbose(ctx, model, maxTicks, trace)
+	} else if set.animate {
+		ticks, success, err = runAnimated(ctx, model, maxTicks, set.fps)
+	} else if set.interactive {
+		ticks, success, err = runInteractive(ctx, model, maxTicks, os.Stdin)
+	} else if set.gifFile != "" {
+		g := &gifRecorder{every: set.gifEvery, palette: set.palette}
+		ticks, success, err = runRecorded(ctx, model, maxTicks, g.every, func(int64) { g.capture(model) })
+		if err == nil {
+			if werr := g.write(set.gifFile); werr != nil {
+				log.Fatal(werr)
+			}
 		}
-		count += int(model[y])
-
-		y = (idx + x) % len(model)
-		if y < 0 {
-			y += len(model)
+	} else if set.snapshotCount > 0 {
+		// sample with a generator of its own, so the run is the same as without,
+		// seeded apart from the model's so the two don't draw the same numbers
+		sampler := schelling.NewSampler(set.snapshotCount, rand.New(rand.NewSource(seed^samplerSeedMask)))
+		ticks, success, err = sampleSnapshots(ctx, model, maxTicks, set.snapshotFile, sampler)
+	} else if set.snapshotFile != "" {
+		ticks, success, err = runSnapshots(ctx, model, maxTicks, set.snapshotFile, set.snapshotEvery)
+	} else {
+		ticks, success, err = model.RunToEquilibriumContext(ctx, maxTicks)
+	}
+	if err != nil {
+		return r, err
+	}
+	r.moves = model.Moves()
+	if set.firewallSize > 0 {
+		r.firstFirewall = model.FirstFirewallTick(set.firewallSize)
+	}
+	r.gaveUp = model.GaveUp()
+	r.finalUnhappy = model.UnhappyFraction()
+	r.maxUnhappy = model.MaxUnhappyFraction()
+	r.elapsed = time.Since(started)
+	r.ticksRun = ticks
+	if set.pngFile != "" {
+		if err := writePNG(set.pngFile, model, set.palette); err != nil {
+			log.Fatal(err)
 		}
-		count += int(model[y])
 	}
-
-	if model[idx] == 0 { // invert for agents of type zero
-		count = 2*vision - count
+	if set.saveFile != "" {
+		if err := saveSnapshot(set.saveFile, model, ticks); err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	neighbors := float64(count) / float64((2 * vision))
-	if neighbors < tolerance {
-		return false
+	if success || cfg.Beta > 0 {
+		// a model with stochastic moves never converges, so measure the
+		// state it reaches at the tick cap instead
+		r.finalGroups = model.CountDistinct()
+		r.finalInterfaces = model.Interfaces()
+		r.segregation = model.Segregation()
+		r.entropy = model.Entropy()
+		r.clusterSizes = model.ClusterSizes()
+		r.minCluster, r.maxCluster, r.meanCluster = clusterStats(r.clusterSizes)
+		if set.dumpDir != "" {
+			if err := saveSnapshot(dumpName(set.dumpDir, runNumber, seed, set.dumpGzip), model, ticks); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	if success {
+		if set.verbose {
+			trace.end(r, ticks)
+		}
+		r.ticks = ticks
+		r.status = statusConverged
+	} else if model.Plateaued() {
+		r.status = statusPlateau
+	} else if cfg.Beta == 0 && model.StepsSinceProgress() >= int64(maxTicks)/2 {
+		// the number of unhappy agents has not reached a new low for the
+		// second half of the run, so more ticks are unlikely to help
+		r.status = statusCycling
 	}
-	return true
-}
 
-func step(model model, generator *rand.Rand) {
-	// Using random activation, find an unhappy agent and
-	// tell it to move.
+	return r, nil
+}
 
-	idx := generator.Intn(len(model))
+func onStep(model *schelling.Model, f func(tick int64, m *schelling.Model)) {
+	// Have model call f after every step, after anything it already calls,
+	// so that several modes can follow one run of RunToEquilibriumContext.
 
-	// cycle until you find an unhappy agent
-	for isHappy(model, idx) {
-		idx = generator.Intn(len(model))
+	prev := model.OnStep
+	model.OnStep = func(tick int64, m *schelling.Model) {
+		if prev != nil {
+			prev(tick, m)
+		}
+		f(tick, m)
 	}
-	move(model, idx, generator)
 }
 
-func move(model model, idx int, generator *rand.Rand) {
-	// Move an unhappy agent to new places in the model at random until it is happy.
-	// TODO: Some method of tracking unhappy users could reduce randomness here.
-	// TODO: IIRC, this is slightly more random than the Brandt model. Update comment with clarification.
+// Subcommands. run does runs of a single configuration, sweep does runs
+// over ranges of neighborhood sizes and tolerances, and analyze measures a
+// saved model without simulating.
+const (
+	cmdRun     = "run"
+	cmdSweep   = "sweep"
+	cmdAnalyze = "analyze"
+)
 
-	tries := 0
-	unhappy := true
+func main() {
+	// Dispatch to the subcommand named by the first argument. Without one,
+	// behave as before there were subcommands: like run, but accepting
+	// lists and ranges of neighborhood sizes and tolerances, as sweep does.
 
-	// arbitary number of tries to avoid infinite loops
-	for unhappy && tries < (2*len(model)) {
+	cmd, args := "", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+	switch cmd {
+	case "", cmdRun, cmdSweep:
+		simulate(cmd, args)
+	case cmdAnalyze:
+		analyze(args)
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown subcommand %q: use run, sweep or analyze.\n", cmd)
+		os.Exit(1)
+	}
+}
 
-		val := model[idx]                             // store the agent type
-		model = append(model[:idx], model[idx+1:]...) // delete the model index
-		idx = generator.Intn(len(model))              // randomly generate a new index
+func simulate(cmd string, args []string) {
+	// Do the runs of the run or sweep subcommand, or of no subcommand.
 
-		// the next three lines insert the agent into the new index
-		model = append(model, 0)
-		copy(model[idx+1:], model[idx:])
-		model[idx] = val
+	name := os.Args[0]
+	if cmd != "" {
+		name += " " + cmd
+	}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	single := cmd != cmdSweep // flags for watching or saving a single run
 
-		tries++
-		unhappy = !isHappy(model, idx) // evaluate the agent's happiness at the new location
+	// initialize model variables from console input
+	var numRuns int
+	var cfg schelling.Config
+	var set settings
+	var profileRun bool
+	var profileType string
+	var colors string
+	var logFormat string
+	var initFile string
+	var maxTicks string
+	var ratio float64
+	var wleft, wright int
+	var t0, t1 float64
+	var sizeList, visionList, toleranceList string
+	var timeout time.Duration
+	var dryRun bool
+	var maxProcs int
+	var configFile string
+
+	sizeHelp := "number of agents in the model"
+	if cmd != cmdRun {
+		sizeHelp += ", or a list or range start:stop:step of numbers to sweep, to see how runs scale with the model. rings and lines only"
+	}
+	fs.StringVar(&sizeList, "s", "", sizeHelp)
+	fs.IntVar(&cfg.Dim, "dim", 1, "model dimension: 1 for a ring, 2 for a grid")
+	fs.StringVar(&cfg.Topology, "topology", schelling.Ring, "ring to wrap around the edges of the model, line not to")
+	fs.StringVar(&cfg.Neighborhood, "neighborhood", "", "cells an agent sees on a grid: moore for every cell within -w in both directions, vonneumann for those within -w steps along rows and columns. defaults to moore")
+	fs.IntVar(&cfg.Width, "width", 0, "grid width (2-D models only)")
+	fs.IntVar(&cfg.Height, "height", 0, "grid height (2-D models only)")
+	fs.IntVar(&numRuns, "n", 0, "number of model runs")
+	fs.StringVar(&initFile, "init", "", "file holding the initial model, as printed by -v, in place of a random one. sets the size and shape of the model")
+	fs.IntVar(&cfg.Groups, "k", 2, "number of groups (agent types)")
+	fs.Float64Var(&cfg.Density, "density", 1, "fraction of cells occupied by agents")
+	fs.Float64Var(&ratio, "ratio", 0, "expected fraction of agents of type 1, with two groups. defaults to half")
+	fs.IntVar(&cfg.InitClusters, "init-clusters", 0, "start from about this many blocks of agents, each of a single type, the types taking turns, instead of at random")
+	fs.BoolVar(&cfg.Exact, "exact", false, "split the agents between the types exactly, rather than in expectation. with an odd number of agents in two equal groups, type 0 has one more")
+	visionHelp, toleranceHelp := "neighborhood size", "agent tolerance"
+	if cmd != cmdRun {
+		visionHelp += ", or a list or range start:stop:step of sizes to sweep"
+		toleranceHelp += ", or a list or range start:stop:step of tolerances to sweep"
 	}
-}
 
-func main() {
-	// seed RNG
-	rand.Seed(time.Now().UTC().UnixNano())
+	fs.StringVar(&visionList, "w", "", visionHelp)
+	fs.IntVar(&wleft, "wleft", -1, "neighborhood size to the left, on a ring or line. defaults to -w")
+	fs.IntVar(&wright, "wright", -1, "neighborhood size to the right, on a ring or line. defaults to -w")
+	fs.StringVar(&toleranceList, "t", "0", toleranceHelp)
+	fs.BoolVar(&cfg.Strict, "strict", false, "agents are happy only if the fraction of same-type neighbors exceeds their tolerance. by default, meeting it is enough")
+	fs.StringVar(&cfg.TypeGlyphs, "glyphs", "", "characters to print agents of each type as, in order of type, such as XO#@. defaults to "+schelling.Glyphs+". -init reads only models printed with the defaults")
+	fs.StringVar(&cfg.Weights, "weights", schelling.Uniform, "how much neighbors count by distance: uniform, or falling off linear or gaussian")
+	fs.IntVar(&cfg.ThresholdCount, "threshold-count", 0, "make agents happy if at least this many neighbors are of their own type, in place of a tolerance. cannot be used with -t")
+	fs.Float64Var(&t0, "t0", 0, "tolerance of type 0 agents. defaults to -t")
+	fs.Float64Var(&t1, "t1", 0, "tolerance of type 1 agents. defaults to -t")
+	fs.Var(&cfg.ToleranceDist, "tolerance-dist", "draw each agent's tolerance from a distribution, uniform:low,high or normal:mean,sd")
+	fs.StringVar(&cfg.Movement, "movement", schelling.Random, "how unhappy agents move: random until happy, best response, or swap with another unhappy agent")
+	fs.Float64Var(&cfg.Beta, "move-prob", 0, "let every agent move with a logit probability 1/(1+exp(beta*(f-t))) of its same-type fraction f and tolerance t, with this beta, instead of only unhappy agents. runs then last until the tick cap")
+	fs.Float64Var(&cfg.ConvergeEps, "converge-eps", 0, "count a run as converged once fewer than this fraction of the agents are unhappy, rather than none. the final.unhappy column gives the fraction a run ended with")
+	fs.IntVar(&cfg.PlateauTicks, "plateau-ticks", 0, "give up on a run, with status plateau, once this many ticks pass without the number of unhappy agents reaching a new low. saves time where there is no equilibrium")
+	fs.Float64Var(&cfg.PlateauEps, "plateau-eps", 0, "with -plateau-ticks, count only new lows more than this fraction of the agents below the last")
+	fs.IntVar(&cfg.MoveAttempts, "move-attempts", 0, "most places an unhappy agent tries under random movement before giving up until it is next chosen. defaults to twice the number of cells")
+	fs.Float64Var(&cfg.Anchored, "anchored", 0, "fraction of agents, chosen at random, that never move. needs empty cells")
+	fs.StringVar(&cfg.Activation, "activation", schelling.Async, "async to move a random unhappy agent each tick, sync to move all of them at once, or sequential to move the first in index order")
+	fs.BoolVar(&set.verbose, "v", false, "verbose console output")
+	fs.BoolVar(&set.quiet, "quiet", false, "print no summary or other information, only errors and the output asked for, such as -o, -v or -histogram")
+	fs.StringVar(&logFormat, "log-format", "", "write verbose output to standard error as structured log records, text or json, instead of plain text")
+	fs.BoolVar(&set.percentiles, "percentiles", true, "report percentiles of ticks to equilibrium. these need memory for every run, so turn them off for huge sweeps")
+	fs.IntVar(&set.firewallSize, "firewall-size", 0, "record the first tick from which the model always holds a firewall of at least this many agents of one type in a row, none of which move again, in first.firewall.tick, or -1 if there is none at the end. tracks when each cell last changed, which takes memory")
+	fs.BoolVar(&set.histogram, "histogram", false, "print the distribution of the sizes of the final groups")
+	fs.BoolVar(&set.groupsHist, "groups-histogram", false, "print a histogram of the number of final groups across the runs, which the mean can hide")
+	fs.IntVar(&set.groupsBin, "groups-bin", 1, "width of the bins of -groups-histogram and -groups-histogram-file")
+	fs.StringVar(&set.groupsFile, "groups-histogram-file", "", "write the histogram of the number of final groups to this file as CSV, a row for each bin")
+	fs.StringVar(&set.dumpDir, "dump-final", "", "write the final model of every run whose final state is measured to a file in this directory, named by run number and seed, as a snapshot that -init and analyze can read")
+	fs.BoolVar(&set.dumpGzip, "dump-gzip", false, "compress the files written by -dump-final with gzip")
+	fs.StringVar(&filename, "o", "", "file to write the results of each run to. defaults to standard output, unless -v, -animate or -interactive print there")
+	fs.BoolVar(&appendOutput, "append", false, "add the runs to the end of the -o file instead of overwriting it, writing the CSV header only if the file is new or empty, and numbering the runs on from those already in it. not for json")
+	fs.StringVar(&format, "format", formatCSV, "format of the output file: csv, json, or jsonl for a JSON object per line")
+	fs.BoolVar(&cfg.PrefixSums, "prefix-sums", false, "count neighbors with prefix sums, in time that doesn't grow with the vision. faster for visions of tens of cells or more on a ring or line with uniform weights")
+	fs.BoolVar(&cfg.Bitsets, "bitsets", false, "count neighbors 64 cells at a time from bitsets of the cells. faster for wide visions with two groups on a ring or line with uniform weights. cannot be used with -prefix-sums")
+	fs.IntVar(&cfg.ScanWorkers, "scan-workers", 0, "goroutines sharing each scan of the whole model within a run, such as measuring segregation. helps only with models of a million or so cells")
+	fs.IntVar(&set.numWorkers, "p", runtime.NumCPU(), "number of workers doing runs in parallel. set to 0 for serial")
+	fs.IntVar(&maxProcs, "gomaxprocs", 0, "most CPU cores to use at once, however many workers -p starts. defaults to all of them, or $GOMAXPROCS")
+	fs.BoolVar(&profileRun, "profile", false, "profile application run")
+	fs.StringVar(&profileType, "profile-type", "cpu", "kind of profile to take with -profile: cpu, mem, block or mutex")
+	fs.StringVar(&maxTicks, "maxticks", "500x", "ticks after which a run that has not converged is abandoned, either absolute or, with an x suffix, per agent")
+	fs.DurationVar(&timeout, "timeout", 0, "stop after this long, keeping the runs completed so far (e.g. 90m). zero means no limit")
+	fs.BoolVar(&dryRun, "dry-run", false, "print the configuration that would be run, check the output file can be written, and exit")
+	fs.Int64Var(&set.seed, "seed", 0, "base seed from which each run's seed is drawn. a sweep reuses the same seeds for every combination of parameters, pairing their runs. defaults to the current time")
+	if single {
+		fs.BoolVar(&set.animate, "animate", false, "redraw the model in place as it evolves. needs -n 1 and -p 0")
+		fs.IntVar(&set.fps, "fps", 10, "frames per second for -animate")
+		fs.BoolVar(&set.interactive, "interactive", false, "step the model as you ask on standard input, printing it after each step: enter for one step, a number for that many, r to run to the end, q to quit. needs -n 1 and -p 0")
+		fs.StringVar(&set.gifFile, "gif", "", "write an animated GIF of the run to this file. needs -n 1 and -p 0")
+		fs.IntVar(&set.gifEvery, "gif-every", 1, "ticks between frames of the -gif animation")
+		fs.StringVar(&set.snapshotFile, "snapshot-file", "", "append the model to this file every -snapshot-interval ticks. -init can start from the last snapshot. needs -n 1 and -p 0")
+		fs.IntVar(&set.snapshotEvery, "snapshot-interval", 1, "ticks between snapshots written to -snapshot-file")
+		fs.IntVar(&set.snapshotCount, "snapshot-count", 0, "instead of a snapshot every -snapshot-interval ticks, write this many to -snapshot-file, sampled uniformly from the whole run, keeping only that many in memory")
+		fs.StringVar(&set.saveFile, "save", "", "write the final model to this file as a snapshot, for -init to start a new run from, perhaps with other parameters. needs -n 1")
+		fs.StringVar(&set.pngFile, "png", "", "write a PNG image of the final model to this file. needs -n 1")
+		fs.StringVar(&colors, "colors", defaultColors, "colors of each type in -gif and -png images, as #rrggbb,#rrggbb,...")
+		fs.Int64Var(&set.runSeed, "run-seed", 0, "do a single run (-n 1) with this seed, as recorded in the seed column of an earlier run, to reproduce it")
+	}
+	fs.StringVar(&configFile, "config", "", "read flags from this JSON file, an object of flag names and values such as {\"s\": 1000, \"w\": \"1:8:1\", \"t\": [0.3, 0.5]}. flags on the command line override it")
+	fs.Parse(args)
+	if configFile != "" {
+		if err := applyConfigFile(fs, configFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: bad config file: %v\n", err)
+			os.Exit(1)
+		}
+	}
 
-	// initialize model variables from console input
-	var numAgents, numRuns int
-
-	flag.IntVar(&numAgents, "s", 0, "number of agents in the model")
-	flag.IntVar(&numRuns, "n", 0, "number of model runs")
-	flag.IntVar(&vision, "w", 0, "neighborhood size")
-	flag.Float64Var(&tolerance, "t", 0, "agent tolerance")
-	flag.BoolVar(&verbose, "v", false, "verbose console output")
-	flag.StringVar(&filename, "o", "", "filename to write to, if necessary")
-	flag.IntVar(&numChunks, "p", runtime.NumCPU(), "number of chunks to split the runs into. set to 1 for serial")
-	flag.BoolVar(&profileRun, "profile", false, "profile application run")
-	flag.Parse()
+	// seed RNG, falling back to the current time if no seed was given
+	seedSet := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "seed" {
+			seedSet = true
+		}
+		if f.Name == "run-seed" {
+			set.runSeedSet = true
+		}
+	})
+	if !seedSet {
+		set.seed = time.Now().UTC().UnixNano()
+	}
+	switch {
+	case set.quiet:
+	case set.runSeedSet:
+		fmt.Fprintf(os.Stderr, "Run seed = %d\n", set.runSeed)
+	default:
+		fmt.Fprintf(os.Stderr, "Seed = %d\n", set.seed)
+	}
 
 	// input validation
 	if profileRun {
-		defer profile.Start(profile.CPUProfile, profile.ProfilePath(".")).Stop()
+		mode, ok := profileTypes[profileType]
+		if !ok {
+			fmt.Fprintln(os.Stderr, "Error: profile type must be cpu, mem, block or mutex.")
+			os.Exit(1)
+		}
+		defer profile.Start(mode, profile.ProfilePath(".")).Stop()
 	}
-	if numChunks == 0 {
-		parallel = false
+	if maxProcs < 0 {
+		fmt.Fprintln(os.Stderr, "Error: gomaxprocs cannot be negative.")
+		os.Exit(1)
+	}
+	if maxProcs > 0 {
+		runtime.GOMAXPROCS(maxProcs)
+	}
+	if set.numWorkers == 0 {
+		set.parallel = false
 	} else {
-		parallel = true
-		fmt.Printf("GOMAXPROCS = %d\n", runtime.NumCPU())
+		set.parallel = true
+		if !set.quiet {
+			fmt.Fprintf(os.Stderr, "GOMAXPROCS = %d\n", runtime.GOMAXPROCS(0))
+		}
 	}
-	if numAgents <= 0 {
-		fmt.Println("Please enter the number of agents to simulate.")
+	if initFile != "" {
+		snapshots, err := readModels(initFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: bad initial model: %v\n", err)
+			os.Exit(1)
+		}
+		// start from the last snapshot of an earlier run
+		last := snapshots[len(snapshots)-1]
+		cfg.Layout, cfg.Width, cfg.Height = last.Layout, last.Width, last.Height
+		if last.Tick > 1 && !set.quiet {
+			fmt.Fprintf(os.Stderr, "Starting from tick %d of an earlier run; ticks are counted afresh\n", last.Tick)
+		}
+		cfg.Size = len(cfg.Layout)
+		cfg.Dim = 1
+		if cfg.Height > 1 {
+			cfg.Dim = 2
+		}
+	}
+	if cfg.Dim == 2 {
+		if cfg.Width <= 0 || cfg.Height <= 0 {
+			fmt.Fprintln(os.Stderr, "Please enter the width and height of the grid.")
+			os.Exit(1)
+		}
+		cfg.Size = cfg.Width * cfg.Height
+	}
+	// the size of a grid or an initial model is already fixed; otherwise
+	// -s gives it, or a list of sizes to sweep
+	sizes := []int{cfg.Size}
+	if cfg.Size == 0 && sizeList != "" {
+		var err error
+		sizes, err = parseIntRange(sizeList)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: bad number of agents: %v\n", err)
+			os.Exit(1)
+		}
+	} else if strings.ContainsAny(sizeList, ",:") {
+		fmt.Fprintln(os.Stderr, "Error: a list of sizes needs a ring or line without -init.")
 		os.Exit(1)
 	}
+	for _, size := range sizes {
+		if size <= 0 {
+			fmt.Fprintln(os.Stderr, "Please enter the number of agents to simulate.")
+			os.Exit(1)
+		}
+	}
+	set.sizeSweep = len(sizes) > 1
 	if numRuns <= 0 {
-		fmt.Println("Please enter the number of model runs to be performed.")
+		fmt.Fprintln(os.Stderr, "Please enter the number of model runs to be performed.")
+		os.Exit(1)
+	}
+	if cfg.Density <= 0 { // the library would take zero to mean the default
+		fmt.Fprintln(os.Stderr, "Error: density must be a decimal greater than zero and at most one.")
 		os.Exit(1)
 	}
-	if vision <= 0 {
-		fmt.Println("Please enter the desired neighborhood size.")
+	if ratio != 0 {
+		if cfg.Groups != 2 || ratio < 0 || ratio >= 1 {
+			fmt.Fprintln(os.Stderr, "Error: ratio must be a decimal greater than zero and less than one, with two groups.")
+			os.Exit(1)
+		}
+		cfg.Shares = []float64{1 - ratio, ratio}
+	}
+	if visionList == "" {
+		fmt.Fprintln(os.Stderr, "Please enter the desired neighborhood size.")
 		os.Exit(1)
 	}
-	if tolerance <= 0 || tolerance >= 1 {
-		fmt.Println("Error: tolerance must be a decimal greater than zero and less than one.")
+	visions, err := parseIntRange(visionList)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: bad neighborhood size: %v\n", err)
 		os.Exit(1)
 	}
-	if vision > numAgents {
-		fmt.Println("Error: vision cannot be greater than the number of agents.")
+	tolerances, err := parseFloatRange(toleranceList)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: bad tolerance: %v\n", err)
+		os.Exit(1)
+	}
+
+	cfg.TrackChanges = set.firewallSize > 0 // for FirstFirewallTick
+
+	// build and check the configuration for every combination of size,
+	// vision and tolerance, so that a sweep fails before any runs rather than
+	// partway through
+	var configs []schelling.Config
+	for _, size := range sizes {
+		for _, vision := range visions {
+			for _, tolerance := range tolerances {
+				c := cfg
+				c.Size = size
+				c.Vision = vision
+				c.Tolerance = tolerance
+				if wleft >= 0 || wright >= 0 {
+					c.VisionLeft, c.VisionRight = vision, vision
+					if wleft >= 0 {
+						c.VisionLeft = wleft
+					}
+					if wright >= 0 {
+						c.VisionRight = wright
+					}
+				}
+				if t0 != 0 || t1 != 0 {
+					c.GroupTolerances = []float64{t0, t1}
+					if t0 == 0 {
+						c.GroupTolerances[0] = tolerance
+					}
+					if t1 == 0 {
+						c.GroupTolerances[1] = tolerance
+					}
+				}
+				if err := c.Validate(); err != nil {
+					fmt.Fprintf(os.Stderr, "Error: %v.\n", err)
+					os.Exit(1)
+				}
+				configs = append(configs, c)
+			}
+		}
+	}
+	if cmd == cmdRun && len(configs) > 1 {
+		fmt.Fprintln(os.Stderr, "Error: run takes a single number of agents, neighborhood size and tolerance. use sweep for lists and ranges.")
 		os.Exit(1)
 	}
-	if verbose && parallel {
-		fmt.Println("Error: verbose and parallel cannot be enabled at the same time.")
+	set.perCell = strings.HasSuffix(maxTicks, "x")
+	set.maxTicks, err = strconv.Atoi(strings.TrimSuffix(maxTicks, "x"))
+	if err != nil || set.maxTicks <= 0 {
+		fmt.Fprintln(os.Stderr, "Error: maxticks must be a whole number greater than zero, optionally followed by x.")
 		os.Exit(1)
 	}
-	if filename == "" {
-		writeToFile = false
-	} else {
-		writeToFile = true
+	if set.animate {
+		if set.verbose || set.parallel || numRuns != 1 || len(configs) != 1 {
+			fmt.Fprintln(os.Stderr, "Error: animate needs a single serial run (-n 1 -p 0) without verbose.")
+			os.Exit(1)
+		}
+		if configs[0].Size > maxAnimateCells {
+			fmt.Fprintf(os.Stderr, "Error: animate can draw at most %d cells.\n", maxAnimateCells)
+			os.Exit(1)
+		}
+		if set.fps <= 0 {
+			fmt.Fprintln(os.Stderr, "Error: fps must be greater than zero.")
+			os.Exit(1)
+		}
+	}
+	if set.interactive && (set.verbose || set.animate || set.gifFile != "" || set.snapshotFile != "" || set.parallel || numRuns != 1 || len(configs) != 1) {
+		fmt.Fprintln(os.Stderr, "Error: interactive needs a single serial run (-n 1 -p 0) without verbose, animate, gif or snapshot-file.")
+		os.Exit(1)
+	}
+	if set.gifFile != "" {
+		if set.verbose || set.animate || set.parallel || numRuns != 1 || len(configs) != 1 {
+			fmt.Fprintln(os.Stderr, "Error: gif needs a single serial run (-n 1 -p 0) without verbose or animate.")
+			os.Exit(1)
+		}
+		if set.gifEvery <= 0 {
+			fmt.Fprintln(os.Stderr, "Error: gif-every must be greater than zero.")
+			os.Exit(1)
+		}
+	}
+	if set.snapshotFile != "" {
+		if set.verbose || set.animate || set.gifFile != "" || set.parallel || numRuns != 1 || len(configs) != 1 {
+			fmt.Fprintln(os.Stderr, "Error: snapshot-file needs a single serial run (-n 1 -p 0) without verbose, animate or gif.")
+			os.Exit(1)
+		}
+		if set.snapshotEvery <= 0 {
+			fmt.Fprintln(os.Stderr, "Error: snapshot-interval must be greater than zero.")
+			os.Exit(1)
+		}
+		if set.snapshotCount > 0 && set.snapshotEvery != 1 {
+			fmt.Fprintln(os.Stderr, "Error: snapshot-count and snapshot-interval cannot both be set.")
+			os.Exit(1)
+		}
+	}
+	if set.dumpGzip && set.dumpDir == "" {
+		fmt.Fprintln(os.Stderr, "Error: dump-gzip needs a dump-final directory.")
+		os.Exit(1)
+	}
+	if set.groupsBin <= 0 {
+		fmt.Fprintln(os.Stderr, "Error: groups-bin must be greater than zero.")
+		os.Exit(1)
+	}
+	if set.firewallSize < 0 {
+		fmt.Fprintln(os.Stderr, "Error: firewall-size cannot be negative.")
+		os.Exit(1)
+	}
+	if set.snapshotCount < 0 || (set.snapshotCount > 0 && set.snapshotFile == "") {
+		fmt.Fprintln(os.Stderr, "Error: snapshot-count must be greater than zero, with a snapshot-file.")
+		os.Exit(1)
+	}
+	if set.pngFile != "" && (numRuns != 1 || len(configs) != 1) {
+		fmt.Fprintln(os.Stderr, "Error: png needs a single run (-n 1).")
+		os.Exit(1)
+	}
+	if set.runSeedSet && (numRuns != 1 || len(configs) != 1) {
+		fmt.Fprintln(os.Stderr, "Error: run-seed needs a single run (-n 1).")
+		os.Exit(1)
+	}
+	if set.saveFile != "" && (numRuns != 1 || len(configs) != 1) {
+		fmt.Fprintln(os.Stderr, "Error: save needs a single run (-n 1).")
+		os.Exit(1)
+	}
+	if set.gifFile != "" || set.pngFile != "" {
+		set.palette, err = parseColors(colors)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: bad colors: %v\n", err)
+			os.Exit(1)
+		}
+		if len(set.palette)-1 < cfg.Groups {
+			fmt.Fprintf(os.Stderr, "Error: colors needs a color for each of the %d groups.\n", cfg.Groups)
+			os.Exit(1)
+		}
+	}
+	if format != formatCSV && format != formatJSON && format != formatJSONL {
+		fmt.Fprintln(os.Stderr, "Error: format must be csv, json or jsonl.")
+		os.Exit(1)
+	}
+	if appendOutput && (filename == "" || format == formatJSON) {
+		fmt.Fprintln(os.Stderr, "Error: append needs an output file (-o) in the csv or jsonl format.")
+		os.Exit(1)
+	}
+	if logFormat != "" && logFormat != logText && logFormat != logJSON {
+		fmt.Fprintln(os.Stderr, "Error: log-format must be text or json.")
+		os.Exit(1)
+	}
+	set.logger = newLogger(logFormat)
+	if set.logger != nil {
+		// route errors reported through the log package to the same handler
+		slog.SetDefault(set.logger)
+	}
+	// without -o, results go to standard output, unless the model is
+	// printed there as it runs
+	stdoutTaken := (set.verbose && set.logger == nil) || set.animate || set.interactive
+	writeResults = filename != "" || !stdoutTaken
+	if dryRun {
+		printDryRun(configs, set, numRuns, timeout)
+		return
+	}
+	if !set.quiet && !set.verbose && !set.animate && !set.interactive {
+		set.progress = newProgress(numRuns * len(configs))
+	}
+
+	// stop early, keeping completed runs, on an interrupt or once the timeout passes
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
 	}
 
-	aggregateRuns(numRuns, numAgents, vision, tolerance, verbose)
+	if set.dumpDir != "" {
+		if err := os.MkdirAll(set.dumpDir, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: cannot create the dump-final directory: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if set.snapshotFile != "" {
+		// open it now, so a bad path fails before the run rather than after
+		if err := os.MkdirAll(filepath.Dir(set.snapshotFile), 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: cannot create the directory for the snapshot file: %v\n", err)
+			os.Exit(1)
+		}
+		f, err := os.OpenFile(set.snapshotFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err == nil {
+			err = f.Close()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: cannot open the snapshot file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	firstRun := 0 // after the runs already in the file, with -append
+	if writeResults {
+		var out io.Writer = os.Stdout
+		hasHeader := false // the appended-to file already starts with one
+		if filename != "" {
+			if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: cannot create the directory for the output file: %v\n", err)
+				os.Exit(1)
+			}
+			var f *os.File
+			var err error
+			if appendOutput {
+				f, hasHeader, firstRun, err = openForAppend(filename)
+			} else {
+				f, err = os.Create(filename)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: cannot create the output file: %v\n", err)
+				os.Exit(1)
+			}
+			defer f.Close()
+			out = f
+		}
+		w = bufio.NewWriter(out)
+		defer w.Flush()
+
+		if !appendOutput || !hasHeader {
+			if err := writeHeader(); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	// perform numRuns runs for each combination of parameters, numbering
+	// runs consecutively across the whole sweep
+	var summaries []summary
+	for i, c := range configs {
+		summaries = append(summaries, aggregateRuns(ctx, numRuns, c, set, firstRun+i*numRuns))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if set.sizeSweep && !set.quiet {
+		printScaling(summaries)
+	}
+	if set.groupsFile != "" {
+		if err := writeBins(set.groupsFile, summaries, set.groupsBin); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if writeResults {
+		if err := writeFooter(summaries); err != nil {
+			log.Fatal(err)
+		}
+	}
 }
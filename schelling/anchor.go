@@ -0,0 +1,26 @@
+package schelling
+
+func (m *Model) anchorAgents() {
+	// Fix a fraction Anchored of the agents, rounded down and chosen at
+	// random, in place. Rounding down leaves at least one agent free to move.
+
+	var occupied []int
+	for i, a := range m.agents {
+		if a != Empty {
+			occupied = append(occupied, i)
+		}
+	}
+	m.anchored = make([]bool, m.Size)
+	n := int(m.Anchored * float64(len(occupied)))
+	for i := 0; i < n; i++ {
+		j := i + m.rng.Intn(len(occupied)-i)
+		occupied[i], occupied[j] = occupied[j], occupied[i]
+		m.anchored[occupied[i]] = true
+	}
+}
+
+func (m *Model) IsAnchored(idx int) bool {
+	// Return true if the agent at idx is fixed in place.
+
+	return m.anchored != nil && m.anchored[idx]
+}
@@ -0,0 +1,358 @@
+package schelling
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// model sizes the benchmarks are run at
+var benchSizes = []int{1_000, 10_000, 100_000}
+
+func benchConfig(size int) Config {
+	return Config{Size: size, Vision: 4, Tolerance: 0.5, Density: 0.9}
+}
+
+func benchSized(b *testing.B, f func(b *testing.B, size int)) {
+	// Run f as a sub-benchmark at each of benchSizes.
+
+	for _, size := range benchSizes {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) { f(b, size) })
+	}
+}
+
+func BenchmarkStep(b *testing.B) {
+	benchSized(b, func(b *testing.B, size int) {
+		rng := rand.New(rand.NewSource(1))
+		m := New(benchConfig(size), rng)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if m.Converged() {
+				b.StopTimer()
+				m = New(benchConfig(size), rng)
+				b.StartTimer()
+			}
+			m.Step()
+		}
+	})
+}
+
+func BenchmarkMove(b *testing.B) {
+	benchSized(b, func(b *testing.B, size int) {
+		rng := rand.New(rand.NewSource(1))
+		m := New(benchConfig(size), rng)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if len(m.unhappy) == 0 {
+				b.StopTimer()
+				m = New(benchConfig(size), rng)
+				b.StartTimer()
+			}
+			m.move(m.unhappy[rng.Intn(len(m.unhappy))])
+		}
+	})
+}
+
+func BenchmarkIsHappy(b *testing.B) {
+	benchSized(b, func(b *testing.B, size int) {
+		m := New(benchConfig(size), rand.New(rand.NewSource(1)))
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			m.isHappy(i % size)
+		}
+	})
+}
+
+func BenchmarkConverged(b *testing.B) {
+	benchSized(b, func(b *testing.B, size int) {
+		m := New(benchConfig(size), rand.New(rand.NewSource(1)))
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			m.Converged()
+		}
+	})
+}
+
+func BenchmarkRunToEquilibrium(b *testing.B) {
+	benchSized(b, func(b *testing.B, size int) {
+		rng := rand.New(rand.NewSource(1))
+		for i := 0; i < b.N; i++ {
+			m := New(benchConfig(size), rng)
+			m.RunToEquilibrium(100 * size)
+		}
+	})
+}
+
+func BenchmarkCounting(b *testing.B) {
+	// Count neighbors cell by cell from the []int cells, from prefix sums,
+	// and from bitsets, on a two-type ring of a million cells with a wide
+	// vision, reporting the memory New takes for each cell as well as the
+	// time to count a neighborhood and to take a step.
+
+	for _, tt := range []struct {
+		name    string
+		counter func(*Config)
+	}{
+		{"cells", func(*Config) {}},
+		{"prefixSums", func(c *Config) { c.PrefixSums = true }},
+		{"bitsets", func(c *Config) { c.Bitsets = true }},
+	} {
+		cfg := Config{Size: 1_000_000, Vision: 50, Tolerance: 0.5, Density: 0.9}
+		tt.counter(&cfg)
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		m := New(cfg, rand.New(rand.NewSource(1)))
+		runtime.ReadMemStats(&after)
+		bytesPerCell := float64(after.TotalAlloc-before.TotalAlloc) / float64(cfg.Size)
+
+		b.Run(tt.name+"/count", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				m.sameType(i % cfg.Size)
+			}
+			b.ReportMetric(bytesPerCell, "bytes/cell")
+		})
+		b.Run(tt.name+"/step", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				m.Step()
+			}
+		})
+	}
+}
+
+// A lockedSource is a generator source shared between goroutines behind a
+// mutex, as the global functions of math/rand share theirs.
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
+}
+
+func BenchmarkGenerators(b *testing.B) {
+	// Step models on parallel goroutines, each with a generator of its own,
+	// as runs have, and with generators drawing from one shared source, as
+	// the global functions of math/rand do. Vary the number of goroutines
+	// with -cpu to see the contention for the shared one.
+
+	shared := &lockedSource{src: rand.NewSource(1)}
+	for _, tt := range []struct {
+		name string
+		rng  func(seed int64) *rand.Rand
+	}{
+		{"own", func(seed int64) *rand.Rand { return rand.New(rand.NewSource(seed)) }},
+		{"shared", func(int64) *rand.Rand { return rand.New(shared) }},
+	} {
+		b.Run(tt.name, func(b *testing.B) {
+			var seeds atomic.Int64
+			b.RunParallel(func(pb *testing.PB) {
+				rng := tt.rng(seeds.Add(1))
+				m := New(benchConfig(10_000), rng)
+				for pb.Next() {
+					if m.Converged() {
+						m = New(benchConfig(10_000), rng)
+					}
+					m.Step()
+				}
+			})
+		})
+	}
+}
+
+func nearlyConverged(size int) *Model {
+	// Return a model stepped until fewer than one agent in a thousand, but
+	// at least one, is unhappy, as a run is near its end.
+
+	rng := rand.New(rand.NewSource(1))
+	for {
+		m := New(benchConfig(size), rng)
+		for len(m.unhappy) > 0 && len(m.unhappy) >= max(size/1000, 2) {
+			m.Step()
+		}
+		if len(m.unhappy) > 0 {
+			return m
+		}
+	}
+}
+
+func BenchmarkUnhappyTracking(b *testing.B) {
+	// Near the end of a run, find an unhappy agent and check for convergence
+	// from the set of unhappy agents, and as was done before the set was
+	// kept: by trying agents at random, and by scanning the model.
+
+	benchSized(b, func(b *testing.B, size int) {
+		m := nearlyConverged(size)
+		rng := rand.New(rand.NewSource(1))
+		b.Run("pick=set", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_ = m.unhappy[rng.Intn(len(m.unhappy))]
+			}
+		})
+		b.Run("pick=probe", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				idx := rng.Intn(size)
+				for m.agents[idx] == Empty || m.isHappy(idx) {
+					idx = rng.Intn(size)
+				}
+			}
+		})
+		b.Run("converged=set", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				m.Converged()
+			}
+		})
+		b.Run("converged=scan", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for idx := range m.agents {
+					if !m.isHappy(idx) {
+						break
+					}
+				}
+			}
+		})
+	})
+}
+
+func BenchmarkRelocate(b *testing.B) {
+	// Move a random agent to a random place, by swapping it into an empty
+	// cell, in O(1), and, in a model with no empty cells, by shifting the
+	// cells between its old and new places, in O(Size).
+
+	for _, size := range []int{10_000, 100_000, 1_000_000} {
+		for _, tt := range []struct {
+			name    string
+			density float64
+		}{
+			{"swap", 0.9},
+			{"shift", 1},
+		} {
+			b.Run(fmt.Sprintf("size=%d/%s", size, tt.name), func(b *testing.B) {
+				cfg := benchConfig(size)
+				cfg.Density = tt.density
+				rng := rand.New(rand.NewSource(1))
+				m := New(cfg, rng)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					idx := rng.Intn(size)
+					for m.agents[idx] == Empty {
+						idx = rng.Intn(size)
+					}
+					m.relocateRandomly(idx)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkRunsPool(b *testing.B) {
+	// Do a batch of runs in which the first few take much longer than the
+	// rest, on a pool of workers taking runs as they come, and split into
+	// equal chunks of consecutive runs, one for each worker, as batches were
+	// before. The chunks leave one worker with all the slow runs.
+
+	const n, workers = 32, 4
+	run := func(number int, seed int64) int64 {
+		size := 1_000
+		if number < n/workers {
+			size = 20_000
+		}
+		ticks, _ := New(benchConfig(size), rand.New(rand.NewSource(seed))).RunToEquilibrium(100 * size)
+		return ticks
+	}
+
+	b.Run("pool", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for range Runs(context.Background(), n, workers, 1, func(_ context.Context, number int, seed int64) (int64, error) {
+				return run(number, seed), nil
+			}) {
+			}
+		}
+	})
+	b.Run("chunks", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			seeder := rand.New(rand.NewSource(1))
+			seeds := make([]int64, n)
+			for j := range seeds {
+				seeds[j] = seeder.Int63()
+			}
+			var wg sync.WaitGroup
+			wg.Add(workers)
+			for w := 0; w < workers; w++ {
+				go func() {
+					defer wg.Done()
+					for j := w * n / workers; j < (w+1)*n/workers; j++ {
+						run(j, seeds[j])
+					}
+				}()
+			}
+			wg.Wait()
+		}
+	})
+}
+
+func BenchmarkScanWorkers(b *testing.B) {
+	// Scan a model of a million cells serially and on several goroutines:
+	// the scan for unhappy agents that New makes, which takes the place of
+	// a convergence check, and Segregation and Entropy. The goroutines only
+	// help if there are CPUs for them; see -cpu.
+
+	const size = 1_000_000
+	for _, workers := range []int{1, 4} {
+		cfg := benchConfig(size)
+		cfg.ScanWorkers = workers
+		b.Run(fmt.Sprintf("workers=%d/new", workers), func(b *testing.B) {
+			rng := rand.New(rand.NewSource(1))
+			for i := 0; i < b.N; i++ {
+				New(cfg, rng)
+			}
+		})
+		m := New(cfg, rand.New(rand.NewSource(1)))
+		b.Run(fmt.Sprintf("workers=%d/segregation", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				m.Segregation()
+			}
+		})
+		b.Run(fmt.Sprintf("workers=%d/entropy", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				m.Entropy()
+			}
+		})
+	}
+}
+
+func BenchmarkPrefixSums(b *testing.B) {
+	// Check the happiness of every agent of a million-agent ring with
+	// vision 50, as a full convergence check would, and step it, which
+	// also keeps the prefix sums up to date, with and without them.
+
+	for _, prefixSums := range []bool{false, true} {
+		cfg := Config{Size: 1_000_000, Vision: 50, Tolerance: 0.5, Density: 0.9, PrefixSums: prefixSums}
+		rng := rand.New(rand.NewSource(1))
+		m := New(cfg, rng)
+		b.Run(fmt.Sprintf("prefixSums=%t/happiness", prefixSums), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for idx := range m.agents {
+					m.isHappy(idx)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("prefixSums=%t/step", prefixSums), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				m.Step()
+			}
+		})
+	}
+}
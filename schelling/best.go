@@ -0,0 +1,83 @@
+package schelling
+
+import "math"
+
+func (m *Model) score(idx int) float64 {
+	// Return the fraction of neighbors of the agent at idx that are of the
+	// same type. An agent with no neighbors scores 1, as it is happy.
+
+	f := m.SameTypeFraction(idx, 0)
+	if math.IsNaN(f) {
+		return 1
+	}
+	return f
+}
+
+func (m *Model) bestResponseMove(idx int) {
+	// Move the agent at idx to the place that maximizes the fraction of its
+	// neighbors that are of the same type. Candidates are the agent's current
+	// place and the empty cells if there are any; otherwise every other cell
+	// of a grid, trading places with its agent, or every gap on a ring or line.
+	// Each candidate is scored by making the move and undoing it.
+	// Ties are broken uniformly at random. The move is made even if the agent
+	// is still unhappy afterwards, so a run in which no agent can ever become
+	// happy is ended by the tick cap rather than looping here.
+
+	best, bestScore, ties := idx, m.score(idx), 1
+	consider := func(candidate int, score float64) {
+		if score > bestScore {
+			best, bestScore, ties = candidate, score, 1
+		} else if score == bestScore {
+			ties++
+			if m.rng.Intn(ties) == 0 {
+				best = candidate
+			}
+		}
+	}
+
+	switch {
+	case len(m.empties) > 0:
+		bestEmpty := -1
+		for e, to := range m.empties {
+			m.swap(idx, to)
+			prev := best
+			consider(to, m.score(to))
+			if best != prev {
+				bestEmpty = e
+			}
+			m.swap(idx, to)
+		}
+		if best != idx {
+			m.swap(idx, best)
+			m.empties[bestEmpty] = idx
+		}
+	case m.Dim == 2:
+		for to := range m.agents {
+			if to == idx {
+				continue
+			}
+			m.swap(idx, to)
+			consider(to, m.score(to))
+			m.swap(idx, to)
+		}
+		m.swap(idx, best)
+	default:
+		// Slide the agent from the first gap to the last one swap at a time,
+		// scoring it at each. On a ring the last gap is the same as the first.
+		last := len(m.agents) - 1
+		if !m.bounded {
+			last--
+		}
+		m.relocate(idx, 0)
+		best, bestScore, ties = 0, m.score(0), 1
+		for to := 1; to <= last; to++ {
+			m.swap(to-1, to)
+			consider(to, m.score(to))
+		}
+		m.relocate(last, best)
+	}
+	m.touched = append(m.touched, idx, best)
+	if best != idx {
+		m.moves++
+	}
+}
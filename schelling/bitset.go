@@ -0,0 +1,71 @@
+package schelling
+
+import "math/bits"
+
+// With Bitsets, a model of two types on a ring or line keeps a bit for each
+// cell saying whether it holds an agent, and one saying whether it holds an
+// agent of the first type. A count over a range of cells then takes a
+// popcount for every 64 cells rather than a step for each. The cells holding
+// the second type are the occupied ones that don't hold the first, so they
+// are counted from the other two and take no memory of their own.
+
+// A bitset holds a bit for each cell, packed 64 to a word.
+type bitset []uint64
+
+func newBitset(n int) bitset {
+	return make(bitset, (n+63)/64)
+}
+
+func (b bitset) add(i int, delta int32) {
+	// Set the bit of cell i if delta is positive, else clear it. A cell
+	// counts at most once, as a type either is in a cell or isn't.
+
+	if delta > 0 {
+		b[i/64] |= 1 << (i % 64)
+	} else {
+		b[i/64] &^= 1 << (i % 64)
+	}
+}
+
+func (b bitset) count(lo, hi int) int32 {
+	// Return the number of bits set in cells lo to hi, inclusive.
+
+	if lo > hi {
+		return 0
+	}
+	first, last := lo/64, hi/64
+	loMask := ^uint64(0) << (lo % 64)    // bits lo and above in its word
+	hiMask := ^uint64(0) >> (63 - hi%64) // bits hi and below in its word
+	if first == last {
+		return int32(bits.OnesCount64(b[first] & loMask & hiMask))
+	}
+	n := bits.OnesCount64(b[first]&loMask) + bits.OnesCount64(b[last]&hiMask)
+	for _, w := range b[first+1 : last] {
+		n += bits.OnesCount64(w)
+	}
+	return int32(n)
+}
+
+// A remainder counts the cells counted by all but not by part, which must
+// count a subset of them. It keeps nothing itself, so adding to it does
+// nothing; all and part are kept up to date instead.
+type remainder struct {
+	all, part cellCounter
+}
+
+func (r remainder) add(int, int32) {}
+
+func (r remainder) count(lo, hi int) int32 {
+	return r.all.count(lo, hi) - r.part.count(lo, hi)
+}
+
+func (m *Model) buildBitsets() {
+	// Set up the bitsets of a model of two types from its current contents.
+
+	first, occupied := newBitset(m.Size), newBitset(m.Size)
+	m.typeCounts = []cellCounter{first, remainder{all: occupied, part: first}}
+	m.occupied = occupied
+	for i := range m.agents {
+		m.index(i)
+	}
+}
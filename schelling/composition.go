@@ -0,0 +1,84 @@
+package schelling
+
+func (m *Model) drawType() int {
+	// Return a type drawn at random in the proportions given by Shares.
+
+	r := m.rng.Float64() * sum(m.Shares)
+	for t, share := range m.Shares[:m.Groups-1] {
+		if r < share {
+			return t
+		}
+		r -= share
+	}
+	return m.Groups - 1
+}
+
+func (m *Model) splitExactly() {
+	// Reassign the types of the agents so that the number of each type is
+	// as close to its share of the agents as possible, with the types in a
+	// random order. Any agents left over after rounding down go one each to
+	// the types with the largest remainders, ties going to the lower type.
+
+	shares := m.Shares
+	if shares == nil {
+		shares = make([]float64, m.Groups)
+		for t := range shares {
+			shares[t] = 1
+		}
+	}
+	total := sum(shares[:m.Groups])
+
+	var cells []int
+	for i, x := range m.agents {
+		if x != Empty {
+			cells = append(cells, i)
+		}
+	}
+	counts := make([]int, m.Groups)
+	remainders := make([]float64, m.Groups)
+	assigned := 0
+	for t := range counts {
+		exact := shares[t] / total * float64(len(cells))
+		counts[t] = int(exact)
+		remainders[t] = exact - float64(counts[t])
+		assigned += counts[t]
+	}
+	for ; assigned < len(cells); assigned++ {
+		largest := 0
+		for t := range remainders {
+			if remainders[t] > remainders[largest] {
+				largest = t
+			}
+		}
+		counts[largest]++
+		remainders[largest] = -1
+	}
+
+	m.rng.Shuffle(len(cells), func(i, j int) { cells[i], cells[j] = cells[j], cells[i] })
+	for t, n := range counts {
+		for _, c := range cells[:n] {
+			m.agents[c] = t
+		}
+		cells = cells[n:]
+	}
+}
+
+func (m *Model) Counts() []int {
+	// Return the number of agents of each type, indexed by type.
+
+	counts := make([]int, m.Groups)
+	for _, x := range m.agents {
+		if x != Empty {
+			counts[x]++
+		}
+	}
+	return counts
+}
+
+func sum(xs []float64) float64 {
+	total := 0.0
+	for _, x := range xs {
+		total += x
+	}
+	return total
+}
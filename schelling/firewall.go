@@ -0,0 +1,161 @@
+package schelling
+
+import "math"
+
+// With TrackChanges, a model keeps for each cell the tick from which it has
+// held what it holds now. Moves are often tried and undone, as Best and Swap
+// do, and an agent may move several times in one step, so swap and relocate
+// only note the cells they change, and at the end of the step each noted
+// cell is compared with what it last held, and stamped with the tick the
+// step ends if that differs.
+
+func (m *Model) note(i int) {
+	// Note that cell i may have changed, if changes are tracked.
+
+	if m.since != nil {
+		m.noted = append(m.noted, i)
+	}
+}
+
+func (m *Model) stamp() {
+	// Stamp each noted cell that no longer holds what it did with the tick
+	// the current step ends, as RunToEquilibrium counts them. Step calls it
+	// once its moves are made.
+
+	for _, i := range m.noted {
+		if m.agents[i] != m.held[i] {
+			m.held[i], m.since[i] = m.agents[i], m.steps+2
+		}
+	}
+	m.noted = m.noted[:0]
+}
+
+func (m *Model) HeldSince(idx int) int64 {
+	// Return the tick from which cell idx has held what it holds now, counted
+	// as RunToEquilibrium counts them from when the model was made, or 0 if
+	// the model doesn't have TrackChanges.
+
+	if m.since == nil {
+		return 0
+	}
+	return m.since[idx]
+}
+
+func (m *Model) FirstFirewallTick(size int) int64 {
+	// Return the first tick from which the model has always held a firewall
+	// of at least size agents: that many agents of one type in a row, as
+	// ClusterSizes counts groups, none of whose cells, nor the empty cells
+	// between them, have changed since. On a grid a firewall is a whole group
+	// of at least size agents. Return -1 if the model holds no firewall that
+	// large, or 0 if it doesn't have TrackChanges.
+
+	if m.since == nil {
+		return 0
+	}
+	if m.Dim == 2 {
+		return m.firstFirewallTick2d(size)
+	}
+
+	var cells []int // of the agents, in order
+	for i, a := range m.agents {
+		if a != Empty {
+			cells = append(cells, i)
+		}
+	}
+	n := len(cells)
+	if size < 1 || n < size {
+		return -1
+	}
+
+	// On a ring, start at the first agent of a group, so that no group is
+	// split between the end and the start. If there is none, every agent is
+	// in one group that goes all the way around.
+	start := 0
+	if !m.bounded {
+		for start < n && m.agents[cells[start]] == m.agents[cells[(start+n-1)%n]] {
+			start++
+		}
+	}
+	around := start == n
+	if around {
+		start = 0
+	}
+
+	// Walk the agents from start, listing for each group the tick each of
+	// its agents has been in place since, and after each but the last the
+	// latest tick any of the empty cells before the next agent changed.
+	first := int64(-1)
+	var ticks []int64
+	gap := func(from, to int) int64 { // latest change in the cells after from up to to
+		latest := int64(0)
+		for i := (from + 1) % len(m.agents); i != to; i = (i + 1) % len(m.agents) {
+			latest = max(latest, m.since[i])
+		}
+		return latest
+	}
+	for k := 0; k < n; k++ {
+		c := cells[(start+k)%n]
+		ticks = append(ticks, m.since[c])
+		next := cells[(start+k+1)%n]
+		last := k == n-1
+		if !last && m.agents[next] == m.agents[c] {
+			ticks = append(ticks, gap(c, next))
+			continue
+		}
+		if around {
+			// the group wraps around, so a firewall may take in the gap
+			// after the last agent and the first agents again
+			ticks = append(ticks, gap(c, next))
+			ticks = append(ticks, ticks[:2*size-2]...)
+		}
+		if (len(ticks)+1)/2 >= size {
+			if t := leastWindowMax(ticks, 2*size-1); first < 0 || t < first {
+				first = t
+			}
+		}
+		ticks = ticks[:0]
+	}
+	return first
+}
+
+func leastWindowMax(vals []int64, width int) int64 {
+	// Return the least, over the windows of width values of vals that start
+	// at an even index, of the greatest value in the window.
+
+	least := int64(math.MaxInt64)
+	var window []int // indices into vals, whose values fall from front to back
+	for i, v := range vals {
+		for len(window) > 0 && vals[window[len(window)-1]] <= v {
+			window = window[:len(window)-1]
+		}
+		window = append(window, i)
+		if window[0] <= i-width {
+			window = window[1:]
+		}
+		if start := i - width + 1; start >= 0 && start%2 == 0 {
+			least = min(least, vals[window[0]])
+		}
+	}
+	return least
+}
+
+func (m *Model) firstFirewallTick2d(size int) int64 {
+	// Return FirstFirewallTick for a grid, from the latest change to any cell
+	// of each cluster of at least size agents.
+
+	labels, n := m.clusters2d()
+	sizes, latest := make([]int, n), make([]int64, n)
+	for i, l := range labels {
+		if l >= 0 {
+			sizes[l]++
+			latest[l] = max(latest[l], m.since[i])
+		}
+	}
+	first := int64(-1)
+	for l := range sizes {
+		if sizes[l] >= size && (first < 0 || latest[l] < first) {
+			first = latest[l]
+		}
+	}
+	return first
+}
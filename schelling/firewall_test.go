@@ -0,0 +1,84 @@
+package schelling
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestFirstFirewallTick(t *testing.T) {
+	// Given the tick each cell has held its contents since, a firewall forms
+	// when the last cell of its span, counting the empty cells between its
+	// agents, settled, and the first firewall to form counts.
+
+	tests := []struct {
+		name     string
+		layout   string
+		topology string
+		since    []int64
+		size     int
+		want     int64
+	}{
+		{"whole block", "XXXOOO", Line, []int64{3, 5, 4, 1, 1, 9}, 3, 5},
+		{"part of a block", "XXXXOO", Line, []int64{9, 2, 3, 4, 1, 1}, 3, 4},
+		{"earliest of two", "XXXOOO", Line, []int64{6, 6, 6, 2, 7, 1}, 3, 6},
+		{"gap between agents", "XX.XOO", Line, []int64{2, 2, 8, 2, 1, 1}, 3, 8},
+		{"empty cells at the ends", ".XXX.O", Line, []int64{9, 2, 2, 3, 9, 1}, 3, 3},
+		{"too small", "XXOOXX", Line, []int64{1, 1, 1, 1, 1, 1}, 3, -1},
+		{"joined around the ring", "XXOOXX", Ring, []int64{2, 3, 1, 1, 4, 1}, 3, 3},
+		{"all one type", "XXXXXX", Ring, []int64{5, 9, 9, 9, 6, 4}, 3, 6},
+		{"all one type, line", "XXXXXX", Line, []int64{5, 9, 9, 9, 6, 4}, 3, 9},
+		{"size 1", "XO.OX", Line, []int64{4, 3, 1, 2, 5}, 1, 2},
+		{"grid", "XXO\nXOO\nOO.", Line, []int64{1, 2, 9, 3, 4, 5, 6, 7, 1}, 3, 3},
+		{"grid, whole cluster", "XXO\nXOO\nOO.", Line, []int64{1, 2, 9, 3, 4, 5, 6, 7, 1}, 4, 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newLayout(t, tt.layout, Config{Topology: tt.topology, Vision: 1, TrackChanges: true})
+			copy(m.since, tt.since)
+			if got := m.FirstFirewallTick(tt.size); got != tt.want {
+				t.Errorf("FirstFirewallTick(%d) = %d, want %d", tt.size, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeldSince(t *testing.T) {
+	// The tick each cell is stamped with is the one after the last frame of
+	// the run in which it held something else, however agents moved there,
+	// trying out places and undoing the moves along the way.
+
+	configs := map[string]Config{
+		"ring":          {Size: 60, Vision: 2, Tolerance: 0.5, Density: 0.8},
+		"full ring":     {Size: 60, Vision: 2, Tolerance: 0.5},
+		"line":          {Size: 60, Topology: Line, Vision: 2, Tolerance: 0.5, Density: 0.8},
+		"grid":          {Dim: 2, Width: 8, Height: 8, Vision: 1, Tolerance: 0.5, Density: 0.8},
+		"best":          {Size: 60, Vision: 2, Tolerance: 0.5, Movement: Best},
+		"best, empties": {Size: 60, Vision: 2, Tolerance: 0.5, Density: 0.8, Movement: Best},
+		"swap":          {Size: 60, Vision: 2, Tolerance: 0.5, Movement: Swap},
+		"sync":          {Size: 60, Vision: 2, Tolerance: 0.5, Density: 0.8, Activation: Sync},
+		"logit":         {Size: 60, Vision: 2, Tolerance: 0.5, Density: 0.8, Beta: 2},
+	}
+	for name, cfg := range configs {
+		t.Run(name, func(t *testing.T) {
+			cfg.TrackChanges = true
+			if err := cfg.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			for seed := int64(0); seed < 10; seed++ {
+				m := New(cfg, rand.New(rand.NewSource(seed)))
+				traj, ticks, _ := m.RunWithTrajectory(300, 1)
+				for i, x := range m.agents {
+					want := int64(1)
+					for _, f := range traj {
+						if f.Agents[i] != x {
+							want = f.Tick + 1
+						}
+					}
+					if got := m.HeldSince(i); got != want {
+						t.Fatalf("seed %d, after %d ticks: cell %d held since %d, want %d", seed, ticks, i, got, want)
+					}
+				}
+			}
+		})
+	}
+}
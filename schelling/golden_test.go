@@ -0,0 +1,57 @@
+package schelling
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func TestGolden(t *testing.T) {
+	// Run models with fixed seeds to equilibrium and compare the final
+	// model and the number of ticks with those in testdata, so that any
+	// change to the dynamics shows up. After a deliberate change, rewrite
+	// the files with go test -run Golden -update.
+
+	tests := []struct {
+		name string
+		seed int64
+		cfg  Config
+	}{
+		{"ring", 1, Config{Size: 60, Vision: 2, Tolerance: 0.5}},
+		{"line", 2, Config{Size: 80, Topology: Line, Vision: 3, Tolerance: 0.4, Density: 0.8}},
+		{"grid", 3, Config{Dim: 2, Width: 12, Height: 12, Vision: 1, Tolerance: 0.5, Density: 0.85}},
+		{"best", 4, Config{Size: 60, Vision: 2, Tolerance: 0.5, Density: 0.9, Movement: Best}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			m := New(tt.cfg, rand.New(rand.NewSource(tt.seed)))
+			ticks, ok := m.RunToEquilibrium(1_000_000)
+			if !ok {
+				t.Fatalf("no equilibrium after %d ticks", ticks)
+			}
+			got := fmt.Sprintf("%s\nticks %d\n", m, ticks)
+
+			name := filepath.Join("testdata", tt.name+".golden")
+			if *update {
+				if err := os.WriteFile(name, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("got\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package schelling
+
+// The two-dimensional model is a Width x Height grid stored row by row in
+// Model.agents. Agents look at their Moore neighborhood, every cell within
+// Chebyshev distance Vision, or with VonNeumann every cell within Manhattan
+// distance Vision. The Ring topology wraps the grid into a torus; on the
+// Line topology the grid is bounded.
+
+func (m *Model) wrap2d(x, y int) int {
+	// Return the index of the cell at column x and row y, wrapping around the
+	// torus, or -1 if the cell is off the edge of a bounded grid.
+
+	if m.bounded && (x < 0 || x >= m.Width || y < 0 || y >= m.Height) {
+		return -1
+	}
+	x %= m.Width
+	if x < 0 {
+		x += m.Width
+	}
+	y %= m.Height
+	if y < 0 {
+		y += m.Height
+	}
+	return y*m.Width + x
+}
+
+func (m *Model) sameType2d(idx int, weights []float64) (same, total float64) {
+	// Count the agents of the same type, and of any type, in the
+	// neighborhood of radius len(weights)-1, each for its weight. Empty
+	// cells in the neighborhood are ignored.
+
+	vision := len(weights) - 1
+	x, y := idx%m.Width, idx/m.Width
+	for dy := -vision; dy <= vision; dy++ {
+		for dx := -vision; dx <= vision; dx++ {
+			d := max(abs(dx), abs(dy))
+			if m.Neighborhood == VonNeumann {
+				d = abs(dx) + abs(dy)
+			}
+			if d == 0 || d > vision {
+				continue
+			}
+			c := m.wrap2d(x+dx, y+dy)
+			if c < 0 || m.agents[c] == Empty {
+				continue
+			}
+			weight := weights[d]
+			if m.agents[c] == m.agents[idx] {
+				same += weight
+			}
+			total += weight
+		}
+	}
+
+	return same, total
+}
+
+func (m *Model) countDistinct2d() int64 {
+	// Count the connected clusters of same-type agents, where agents are
+	// connected to the four cells that share an edge with them. Empty cells
+	// belong to no cluster.
+
+	return int64(len(m.clusterSizes2d()))
+}
+
+func (m *Model) clusterSizes2d() []int {
+	// Return the number of agents in each cluster counted by countDistinct2d.
+
+	labels, n := m.clusters2d()
+	sizes := make([]int, n)
+	for _, l := range labels {
+		if l >= 0 {
+			sizes[l]++
+		}
+	}
+	return sizes
+}
+
+func (m *Model) clusters2d() (labels []int, n int) {
+	// Label each cell with the number of the cluster counted by
+	// countDistinct2d that it belongs to, from 0 to n-1, or -1 if it is
+	// empty.
+
+	labels = make([]int, len(m.agents))
+	for i := range labels {
+		labels[i] = -1
+	}
+	stack := make([]int, 0)
+
+	for start := range m.agents {
+		if labels[start] >= 0 || m.agents[start] == Empty {
+			continue
+		}
+		labels[start] = n
+		stack = append(stack[:0], start)
+		for len(stack) > 0 {
+			idx := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			cx, cy := idx%m.Width, idx/m.Width
+			for _, c := range [4]int{m.wrap2d(cx-1, cy), m.wrap2d(cx+1, cy), m.wrap2d(cx, cy-1), m.wrap2d(cx, cy+1)} {
+				if c >= 0 && labels[c] < 0 && m.agents[c] == m.agents[start] {
+					labels[c] = n
+					stack = append(stack, c)
+				}
+			}
+		}
+		n++
+	}
+
+	return labels, n
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,66 @@
+package schelling
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestSameTypeFraction2d(t *testing.T) {
+	// A 5x5 grid of type 0 with type 1 in the corners and the middle, so
+	// each corner sees the other three around the torus.
+	const grid = "OXXXO\nXXXXX\nXXOXX\nXXXXX\nOXXXO"
+
+	tests := []struct {
+		name         string
+		neighborhood string
+		topology     string
+		vision       int
+		idx          int
+		same         float64
+	}{
+		{"Moore corner", Moore, Ring, 1, 0, 3.0 / 8},
+		{"von Neumann corner", VonNeumann, Ring, 1, 0, 2.0 / 4},
+		{"von Neumann corner, vision 2", VonNeumann, Ring, 2, 0, 3.0 / 12},
+		{"von Neumann far corner", VonNeumann, Ring, 1, 24, 2.0 / 4},
+		{"Moore beside a corner", Moore, Ring, 1, 1, 6.0 / 8},
+		{"von Neumann beside a corner", VonNeumann, Ring, 1, 1, 3.0 / 4},
+		{"Moore middle, vision 2", Moore, Ring, 2, 12, 4.0 / 24},
+		{"von Neumann middle, vision 2", VonNeumann, Ring, 2, 12, 0.0 / 12},
+		{"Moore bounded corner", Moore, Line, 1, 0, 0.0 / 3},
+		{"Moore bounded far corner", Moore, Line, 1, 24, 0.0 / 3},
+		{"von Neumann bounded corner, vision 2", VonNeumann, Line, 2, 0, 0.0 / 5},
+		{"von Neumann bounded edge", VonNeumann, Line, 1, 2, 3.0 / 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newLayout(t, grid, Config{Neighborhood: tt.neighborhood, Topology: tt.topology, Vision: tt.vision})
+			if got := m.SameTypeFraction(tt.idx, 0); got != tt.same {
+				t.Errorf("SameTypeFraction(%d) = %v, want %v", tt.idx, got, tt.same)
+			}
+		})
+	}
+}
+
+func TestEntropy2d(t *testing.T) {
+	// On a checkerboard an agent's Moore window holds itself and four
+	// agents of each type, and its von Neumann window itself and four of
+	// the other type.
+
+	row := "XOXOXO\nOXOXOX\n"
+	board := strings.TrimSuffix(strings.Repeat(row, 3), "\n")
+	entropy := func(p float64) float64 { return -(p*math.Log(p) + (1-p)*math.Log(1-p)) / math.Log(2) }
+
+	for _, tt := range []struct {
+		neighborhood string
+		want         float64
+	}{
+		{Moore, entropy(5.0 / 9)},
+		{VonNeumann, entropy(1.0 / 5)},
+	} {
+		m := newLayout(t, board, Config{Neighborhood: tt.neighborhood, Vision: 1})
+		if got := m.Entropy(); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("%s: Entropy() = %v, want %v", tt.neighborhood, got, tt.want)
+		}
+	}
+}
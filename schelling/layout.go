@@ -0,0 +1,39 @@
+package schelling
+
+import (
+	"fmt"
+	"strings"
+)
+
+func ParseLayout(s string) (layout []int, width, height int, err error) {
+	// Parse a model as String prints it, one row of the grid to a line, into
+	// a Layout, returning the width and height of the grid as well. A ring
+	// or line is a single row. Cells hold a character of Glyphs or '.' for
+	// Empty. Line endings may be "\n" or "\r\n", and a final newline is
+	// allowed.
+
+	s = strings.TrimRight(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+	if s == "" {
+		return nil, 0, 0, fmt.Errorf("layout is empty")
+	}
+	rows := strings.Split(s, "\n")
+	width, height = len(rows[0]), len(rows)
+	layout = make([]int, 0, width*height)
+	for y, row := range rows {
+		if len(row) != width {
+			return nil, 0, 0, fmt.Errorf("row %d of the layout has %d cells, but row 1 has %d", y+1, len(row), width)
+		}
+		for x := 0; x < len(row); x++ {
+			if row[x] == '.' {
+				layout = append(layout, Empty)
+				continue
+			}
+			t := strings.IndexByte(Glyphs, row[x])
+			if t < 0 {
+				return nil, 0, 0, fmt.Errorf("row %d, column %d of the layout: %q is neither an agent (one of %s) nor empty (.)", y+1, x+1, row[x], Glyphs)
+			}
+			layout = append(layout, t)
+		}
+	}
+	return layout, width, height, nil
+}
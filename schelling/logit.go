@@ -0,0 +1,29 @@
+package schelling
+
+import "math"
+
+func (m *Model) logitStep() {
+	// Pick an agent at random, happy or not but not anchored, and move it to
+	// a random place with a probability that falls smoothly from 1 to 0 as
+	// its fraction of same-type neighbors rises past its tolerance, more
+	// sharply the larger Beta is.
+
+	// anchorAgents always leaves an agent free to move, so there is one to
+	// pick unless there are no agents at all
+	if len(m.empties) == len(m.agents) {
+		return
+	}
+	idx := m.rng.Intn(len(m.agents))
+	for m.agents[idx] == Empty || m.IsAnchored(idx) {
+		idx = m.rng.Intn(len(m.agents))
+	}
+	p := 1 / (1 + math.Exp(m.Beta*(m.score(idx)-m.threshold(idx))))
+	if m.rng.Float64() >= p {
+		return
+	}
+	m.touched = append(m.touched, idx)
+	idx = m.relocateRandomly(idx)
+	m.touched = append(m.touched, idx)
+	m.settle()
+	m.moves++
+}
@@ -0,0 +1,185 @@
+package schelling
+
+import "math"
+
+func (m *Model) Segregation() float64 {
+	// Return the mean, over all agents, of the fraction of each agent's
+	// neighbors that are of the same type: the complement of the density of
+	// interfaces between types. Agents with no neighbors are left out, and
+	// if no agent has any neighbors the model is taken to be fully segregated.
+
+	sums, ns := make([]float64, m.scanChunks()), make([]int, m.scanChunks())
+	m.scan(func(chunk, lo, hi int) {
+		for idx := lo; idx < hi; idx++ {
+			if m.agents[idx] == Empty {
+				continue
+			}
+			count, total := m.sameType(idx)
+			if total == 0 {
+				continue
+			}
+			sums[chunk] += count / total
+			ns[chunk]++
+		}
+	})
+	sum, n := 0.0, 0
+	for c := range sums {
+		sum += sums[c]
+		n += ns[c]
+	}
+
+	if n == 0 {
+		return 1
+	}
+	return sum / float64(n)
+}
+
+func (m *Model) ClusterSizes() []int {
+	// Return the number of agents in each group counted by CountDistinct, in
+	// no particular order. On a ring or line a group is a maximal run of
+	// agents of the same type, skipping over empty cells, and on a ring the
+	// runs at either end are joined if they are of the same type.
+
+	if m.Dim == 2 {
+		return m.clusterSizes2d()
+	}
+
+	var sizes []int
+	first, prev := Empty, Empty
+	for _, a := range m.agents {
+		if a == Empty {
+			continue
+		}
+		if first == Empty {
+			first = a
+		}
+		if a == prev {
+			sizes[len(sizes)-1]++
+		} else {
+			sizes = append(sizes, 1)
+		}
+		prev = a
+	}
+
+	// join the last run onto the first, around the ring
+	if !m.bounded && len(sizes) > 1 && prev == first {
+		sizes[0] += sizes[len(sizes)-1]
+		sizes = sizes[:len(sizes)-1]
+	}
+	return sizes
+}
+
+func (m *Model) Entropy() float64 {
+	// Return the mean, over all agents, of the Shannon entropy of the types
+	// in the agent's window: the agent itself and every cell it can see.
+	// The entropy is divided by its largest possible value, log(Groups), so
+	// it runs from 0, when every window holds a single type, to 1, when
+	// every window holds all types equally. Unlike CountDistinct, it keeps
+	// falling as groups grow after the first few boundaries have formed.
+	// Neighbors all count the same, whatever the neighbor weights.
+
+	if m.Groups < 2 {
+		return 0
+	}
+	sums, ns := make([]float64, m.scanChunks()), make([]int, m.scanChunks())
+	m.scan(func(chunk, lo, hi int) {
+		counts := make([]int, m.Groups)
+		for idx := lo; idx < hi; idx++ {
+			if m.agents[idx] == Empty {
+				continue
+			}
+			for t := range counts {
+				counts[t] = 0
+			}
+			m.window(idx, counts)
+			total := 0
+			for _, c := range counts {
+				total += c
+			}
+			h := 0.0
+			for _, c := range counts {
+				if c > 0 {
+					p := float64(c) / float64(total)
+					h -= p * math.Log(p)
+				}
+			}
+			sums[chunk] += h
+			ns[chunk]++
+		}
+	})
+	sum, n := 0.0, 0
+	for c := range sums {
+		sum += sums[c]
+		n += ns[c]
+	}
+
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n) / math.Log(float64(m.Groups))
+}
+
+func (m *Model) window(idx int, counts []int) {
+	// Add the agents in the window of the agent at idx, itself included, to
+	// the counts of their types.
+
+	counts[m.agents[idx]]++
+	if m.Dim == 2 {
+		x, y := idx%m.Width, idx/m.Width
+		for dy := -m.Vision; dy <= m.Vision; dy++ {
+			for dx := -m.Vision; dx <= m.Vision; dx++ {
+				if dx == 0 && dy == 0 || m.Neighborhood == VonNeumann && abs(dx)+abs(dy) > m.Vision {
+					continue
+				}
+				if c := m.wrap2d(x+dx, y+dy); c >= 0 && m.agents[c] != Empty {
+					counts[m.agents[c]]++
+				}
+			}
+		}
+		return
+	}
+
+	for x := 1; x <= m.VisionLeft; x++ {
+		if y := m.neighbor(idx, -x); y >= 0 && m.agents[y] != Empty {
+			counts[m.agents[y]]++
+		}
+	}
+	for x := 1; x <= m.VisionRight; x++ {
+		if y := m.neighbor(idx, x); y >= 0 && m.agents[y] != Empty {
+			counts[m.agents[y]]++
+		}
+	}
+}
+
+func (m *Model) Interfaces() float64 {
+	// Return the fraction of pairs of adjacent agents that are of different
+	// types. Adjacent agents are those in neighboring cells, along a ring or
+	// line or, on a grid, sharing an edge; pairs with an empty cell, and on
+	// a line or bounded grid pairs across the edge, don't count. Return 0 if
+	// there are no pairs.
+
+	pairs, mixed := 0, 0
+	pair := func(i, j int) {
+		if j < 0 || m.agents[i] == Empty || m.agents[j] == Empty {
+			return
+		}
+		pairs++
+		if m.agents[i] != m.agents[j] {
+			mixed++
+		}
+	}
+	for idx := range m.agents {
+		if m.Dim == 2 {
+			x, y := idx%m.Width, idx/m.Width
+			pair(idx, m.wrap2d(x+1, y))
+			pair(idx, m.wrap2d(x, y+1))
+		} else {
+			pair(idx, m.neighbor(idx, 1))
+		}
+	}
+
+	if pairs == 0 {
+		return 0
+	}
+	return float64(mixed) / float64(pairs)
+}
@@ -0,0 +1,165 @@
+package schelling
+
+// An Option sets one parameter of a Config. Options let callers build a
+// Config from only the parameters they care about:
+//
+//	cfg := schelling.NewConfig(schelling.WithSize(100), schelling.WithVision(2), schelling.WithTolerance(0.5))
+type Option func(*Config)
+
+func NewConfig(opts ...Option) Config {
+	// Return a Config with the given options applied, in order, to the zero Config.
+
+	var cfg Config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+func WithSize(size int) Option {
+	return func(c *Config) { c.Size = size }
+}
+
+func WithGrid(width, height int) Option {
+	// Make the model a width by height grid.
+
+	return func(c *Config) {
+		c.Dim = 2
+		c.Width = width
+		c.Height = height
+		c.Size = width * height
+	}
+}
+
+func WithTopology(topology string) Option {
+	return func(c *Config) { c.Topology = topology }
+}
+
+func WithGroups(groups int) Option {
+	return func(c *Config) { c.Groups = groups }
+}
+
+func WithDensity(density float64) Option {
+	return func(c *Config) { c.Density = density }
+}
+
+func WithVision(vision int) Option {
+	return func(c *Config) { c.Vision = vision }
+}
+
+func WithVisionLeftRight(left, right int) Option {
+	// Give agents on a ring or line different visions to the left and to
+	// the right, in place of a single vision.
+
+	return func(c *Config) { c.VisionLeft, c.VisionRight = left, right }
+}
+
+func WithWeights(weights string) Option {
+	return func(c *Config) { c.Weights = weights }
+}
+
+func WithTolerance(tolerance float64) Option {
+	return func(c *Config) { c.Tolerance = tolerance }
+}
+
+func WithGroupTolerances(tolerances ...float64) Option {
+	return func(c *Config) { c.GroupTolerances = tolerances }
+}
+
+func WithShares(shares ...float64) Option {
+	// Give the expected fraction of the agents of each type, one share for
+	// each group, in place of an even split.
+
+	return func(c *Config) { c.Shares = shares }
+}
+
+func WithExact() Option {
+	// Split the agents between the types as nearly in the Shares as can be,
+	// rather than drawing each agent's type at random.
+
+	return func(c *Config) { c.Exact = true }
+}
+
+func WithToleranceDist(d Distribution) Option {
+	return func(c *Config) { c.ToleranceDist = d }
+}
+
+func WithMovement(movement string) Option {
+	return func(c *Config) { c.Movement = movement }
+}
+
+func WithActivation(activation string) Option {
+	return func(c *Config) { c.Activation = activation }
+}
+
+func WithStrict(strict bool) Option {
+	return func(c *Config) { c.Strict = strict }
+}
+
+func WithBeta(beta float64) Option {
+	return func(c *Config) { c.Beta = beta }
+}
+
+func WithAnchored(fraction float64) Option {
+	return func(c *Config) { c.Anchored = fraction }
+}
+
+func WithMoveAttempts(attempts int) Option {
+	return func(c *Config) { c.MoveAttempts = attempts }
+}
+
+func WithThresholdCount(k int) Option {
+	return func(c *Config) { c.ThresholdCount = k }
+}
+
+func WithConvergeEps(eps float64) Option {
+	return func(c *Config) { c.ConvergeEps = eps }
+}
+
+func WithPlateau(ticks int, eps float64) Option {
+	return func(c *Config) { c.PlateauTicks, c.PlateauEps = ticks, eps }
+}
+
+func WithNeighborhood(shape string) Option {
+	return func(c *Config) { c.Neighborhood = shape }
+}
+
+func WithScanWorkers(workers int) Option {
+	return func(c *Config) { c.ScanWorkers = workers }
+}
+
+func WithTypeGlyphs(glyphs string) Option {
+	return func(c *Config) { c.TypeGlyphs = glyphs }
+}
+
+func WithPrefixSums() Option {
+	return func(c *Config) { c.PrefixSums = true }
+}
+
+func WithBitsets() Option {
+	return func(c *Config) { c.Bitsets = true }
+}
+
+func WithTrackChanges() Option {
+	return func(c *Config) { c.TrackChanges = true }
+}
+
+func WithInitClusters(n int) Option {
+	return func(c *Config) { c.InitClusters = n }
+}
+
+func WithLayout(layout []int) Option {
+	// Start the model from the given contents of its cells, such as
+	// ParseLayout returns, rather than at random. The layout must have an
+	// entry for each of the Size cells.
+
+	return func(c *Config) { c.Layout = layout }
+}
+
+func WithOnStep(f func(tick int64, m *Model)) Option {
+	return func(c *Config) { c.OnStep = f }
+}
+
+func WithOnConverge(f func(tick int64, m *Model)) Option {
+	return func(c *Config) { c.OnConverge = f }
+}
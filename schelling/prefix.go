@@ -0,0 +1,117 @@
+package schelling
+
+// With PrefixSums, a model on a ring or line keeps, for each type, a
+// Fenwick tree (binary indexed tree) of the cells holding that type, and one
+// of the cells holding any agent. A count over any range of cells then
+// takes O(log Size) rather than one step per cell, so sameType no longer
+// grows with the vision. Keeping the trees up to date costs O(log Size) for
+// each cell whose contents change.
+
+// A cellCounter counts, for each cell, whether it holds something, and
+// totals the counts over ranges of cells.
+type cellCounter interface {
+	add(i int, delta int32) // add delta to the count of cell i
+	count(lo, hi int) int32 // total count of cells lo to hi, inclusive
+}
+
+// A fenwick holds prefix sums of a count for each cell, updated and queried
+// in O(log n).
+type fenwick []int32
+
+func (f fenwick) add(i int, delta int32) {
+	// Add delta to the count of cell i.
+
+	for i++; i <= len(f); i += i & -i {
+		f[i-1] += delta
+	}
+}
+
+func (f fenwick) sum(i int) int32 {
+	// Return the total count of the cells before i.
+
+	s := int32(0)
+	for ; i > 0; i -= i & -i {
+		s += f[i-1]
+	}
+	return s
+}
+
+func (f fenwick) count(lo, hi int) int32 {
+	return f.sum(hi+1) - f.sum(lo)
+}
+
+func (m *Model) buildCounters(counter func(n int) cellCounter) {
+	// Set up a counter of the cells holding each type, and one of those
+	// holding any agent, from the model's current contents.
+
+	m.typeCounts = make([]cellCounter, m.Groups)
+	for t := range m.typeCounts {
+		m.typeCounts[t] = counter(m.Size)
+	}
+	m.occupied = counter(m.Size)
+	for i := range m.agents {
+		m.index(i)
+	}
+}
+
+func (m *Model) index(i int) {
+	// Count the agent now in cell i, if any, in the counters. Each change to
+	// a cell's contents must be bracketed by unindex and index.
+
+	if m.typeCounts != nil && m.agents[i] != Empty {
+		m.typeCounts[m.agents[i]].add(i, 1)
+		m.occupied.add(i, 1)
+	}
+}
+
+func (m *Model) unindex(i int) {
+	// Stop counting the agent now in cell i, if any, in the counters.
+
+	if m.typeCounts != nil && m.agents[i] != Empty {
+		m.typeCounts[m.agents[i]].add(i, -1)
+		m.occupied.add(i, -1)
+	}
+}
+
+func (m *Model) recount(i, old, new int) {
+	// Count cell i as holding type new, or Empty, in place of old.
+
+	if old == new {
+		return
+	}
+	if old != Empty {
+		m.typeCounts[old].add(i, -1)
+	}
+	if new != Empty {
+		m.typeCounts[new].add(i, 1)
+	}
+	switch {
+	case old == Empty:
+		m.occupied.add(i, 1)
+	case new == Empty:
+		m.occupied.add(i, -1)
+	}
+}
+
+func (m *Model) windowCount(f cellCounter, idx, left, right int) int32 {
+	// Return the count in f of the left cells before idx and the right cells
+	// after it, wrapping around a ring and stopping at the ends of a line.
+
+	n := len(m.agents)
+	count := func(lo, hi int) int32 { // cells lo to hi, inclusive, within the model
+		if lo > hi {
+			return 0
+		}
+		return f.count(lo, hi)
+	}
+
+	lo, hi := idx-left, idx+right
+	total := count(max(lo, 0), idx-1) + count(idx+1, min(hi, n-1))
+	if !m.bounded && lo < 0 {
+		total += count(lo+n, n-1)
+	}
+	if !m.bounded && hi >= n {
+		total += count(0, hi-n)
+	}
+	return total
+}
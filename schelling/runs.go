@@ -0,0 +1,89 @@
+package schelling
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+)
+
+func Runs[T any](ctx context.Context, n, workers int, seed int64, run func(ctx context.Context, number int, seed int64) (T, error)) <-chan T {
+	// Perform n runs, numbered from 0, on a pool of workers goroutines (at
+	// least one), and return a channel delivering the outcome of each run as
+	// it finishes, in no particular order. Each run is done by calling run
+	// with its number and the seed for its own generator; the seeds are drawn
+	// from seed in order of run number, so an outcome depends neither on which
+	// worker does the run nor on how many workers there are. If a run returns
+	// an error, its outcome is dropped and no more runs are started. The
+	// channel is closed once every run started has returned.
+
+	workers = max(workers, 1)
+	results := make(chan T, workers+1)
+
+	type job struct {
+		number int
+		seed   int64
+	}
+	jobs := make(chan job)
+	stop := make(chan struct{}) // closed by the first run to fail
+	var once sync.Once
+
+	// feed the runs to the workers, so that a worker that finishes its runs
+	// quickly picks up more
+	go func() {
+		defer close(jobs)
+		seeder := rand.New(rand.NewSource(seed))
+		for i := 0; i < n; i++ {
+			select {
+			case jobs <- job{number: i, seed: seeder.Int63()}:
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				result, err := run(ctx, j.number, j.seed)
+				if err != nil {
+					once.Do(func() { close(stop) })
+					return
+				}
+				results <- result
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// A Result is the outcome of one run of a batch started by RunBatch.
+type Result struct {
+	Number    int
+	Seed      int64  // New(cfg, rand.New(rand.NewSource(Seed))) sets up the run again
+	Model     *Model // in the state the run ended in
+	Ticks     int64
+	Converged bool
+}
+
+func RunBatch(ctx context.Context, cfg Config, n, workers, maxTicks int, seed int64) <-chan Result {
+	// Run n models set up from cfg to equilibrium, or for at most maxTicks
+	// ticks, as described for Runs, delivering each outcome as it finishes.
+
+	return Runs(ctx, n, workers, seed, func(ctx context.Context, number int, seed int64) (Result, error) {
+		model := New(cfg, rand.New(rand.NewSource(seed)))
+		ticks, ok, err := model.RunToEquilibriumContext(ctx, maxTicks)
+		if err != nil {
+			return Result{}, err
+		}
+		return Result{Number: number, Seed: seed, Model: model, Ticks: ticks, Converged: ok}, nil
+	})
+}
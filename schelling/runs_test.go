@@ -0,0 +1,63 @@
+package schelling
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRunBatch(t *testing.T) {
+	// Many small runs on several workers each arrive exactly once, with the
+	// seed that sets them up again. Run with -race to check the workers and
+	// the consumer for data races.
+
+	const n = 500
+	cfg := Config{Size: 40, Vision: 2, Tolerance: 0.3, Density: 0.8}
+	seen := make([]int, n)
+	for r := range RunBatch(context.Background(), cfg, n, 8, 1000, 1) {
+		if r.Number < 0 || r.Number >= n {
+			t.Fatalf("run number %d out of range", r.Number)
+		}
+		seen[r.Number]++
+		if r.Model == nil || r.Ticks <= 0 {
+			t.Errorf("run %d: model %v after %d ticks", r.Number, r.Model, r.Ticks)
+		}
+	}
+	for number, times := range seen {
+		if times != 1 {
+			t.Errorf("run %d arrived %d times", number, times)
+		}
+	}
+}
+
+func TestRunsSeeds(t *testing.T) {
+	// The seed of each run depends only on its number, not on the number
+	// of workers.
+
+	seeds := func(workers int) map[int]int64 {
+		got := make(map[int]int64)
+		for r := range Runs(context.Background(), 200, workers, 7, func(_ context.Context, number int, seed int64) ([2]int64, error) {
+			return [2]int64{int64(number), seed}, nil
+		}) {
+			if _, ok := got[int(r[0])]; ok {
+				t.Fatalf("%d workers: run %d arrived twice", workers, r[0])
+			}
+			got[int(r[0])] = r[1]
+		}
+		return got
+	}
+	serial := seeds(1)
+	if len(serial) != 200 {
+		t.Fatalf("%d runs arrived, want 200", len(serial))
+	}
+	for _, workers := range []int{2, 16} {
+		parallel := seeds(workers)
+		if len(parallel) != len(serial) {
+			t.Fatalf("%d workers: %d runs arrived, want %d", workers, len(parallel), len(serial))
+		}
+		for number, seed := range serial {
+			if parallel[number] != seed {
+				t.Errorf("%d workers: run %d has seed %d, want %d", workers, number, parallel[number], seed)
+			}
+		}
+	}
+}
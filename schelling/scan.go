@@ -0,0 +1,37 @@
+package schelling
+
+import "sync"
+
+// minScanChunk is the fewest cells worth giving a goroutine of their own in
+// a scan of the whole model.
+const minScanChunk = 1 << 14
+
+func (m *Model) scanChunks() int {
+	// Return the number of chunks scan splits the cells into: one for each
+	// of ScanWorkers, but no more than leaves each a worthwhile share.
+
+	return min(max(m.ScanWorkers, 1), max(len(m.agents)/minScanChunk, 1))
+}
+
+func (m *Model) scan(f func(chunk, lo, hi int)) {
+	// Call f on each of scanChunks contiguous chunks of cells, lo up to hi,
+	// which together cover the model, in parallel if there is more than one.
+	// Chunks are numbered from 0 in order of their cells. f may only read
+	// the model, and write to memory that belongs to its chunk.
+
+	chunks := m.scanChunks()
+	if chunks == 1 {
+		f(0, 0, len(m.agents))
+		return
+	}
+	size := (len(m.agents) + chunks - 1) / chunks
+	var wg sync.WaitGroup
+	for c := 0; c < chunks; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			f(c, c*size, min((c+1)*size, len(m.agents)))
+		}(c)
+	}
+	wg.Wait()
+}
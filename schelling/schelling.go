@@ -0,0 +1,860 @@
+// Package schelling implements the one-dimensional Schelling segregation model,
+// along with the classic two-dimensional grid version.
+//
+// It follows the formalized version of the model described in:
+//
+// Brandt, C., Immorlica, N., Kamath, G., & Kleinberg, R. (2012).
+// An analysis of one-dimensional Schelling segregation.
+// In STOC '12 Proceedings of the forty-fourth annual ACM symposium
+// on theory of computing (p. 789). ACM Press.
+// doi:10.1145/2213977.2214048
+package schelling
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"math/rand"
+)
+
+// declare data types
+
+// Config holds the parameters of a model.
+type Config struct {
+	Size       int     // number of cells; Width*Height for a grid
+	Dim        int     // 1 for a ring, 2 for a grid; zero means 1
+	Topology   string  // Ring (the default) or Line
+	Width      int     // grid width, ignored for a ring
+	Height     int     // grid height, ignored for a ring
+	Groups     int     // number of agent types; zero means 2
+	Density    float64 // fraction of cells occupied by agents; zero means 1
+	Vision     int     // neighborhood size on each side of an agent, under half the ring or grid
+	Tolerance  float64 // minimum fraction of same-type neighbors for an agent to be happy
+	Strict     bool    // if set, an agent must exceed its tolerance, not just meet it, to be happy
+	Weights    string  // how neighbors count by distance: Uniform (the default), Linear or Gaussian
+	Movement   string  // Random (the default), Best or Swap
+	Activation string  // Async (the default), Sync or Sequential
+
+	// Neighborhood is the shape of the neighborhood on a grid: Moore (the
+	// default) or VonNeumann. It must be left empty on a ring or line.
+	Neighborhood string
+
+	// Beta, if greater than zero, replaces the rule that only unhappy agents
+	// move with a logit choice: each tick a random agent, happy or not,
+	// moves to a random place with probability 1/(1+exp(Beta*(f-t))), where
+	// f is its fraction of same-type neighbors and t its tolerance. The
+	// larger Beta is, the closer this comes to the threshold rule. Such a
+	// model never converges, so runs last until their tick cap.
+	Beta float64
+
+	// Anchored is the fraction of agents, chosen at random, that never move,
+	// even when unhappy. A model with anchored agents converges when all of
+	// the others are happy. Other agents move around them through empty
+	// cells, so the model must have some.
+	Anchored float64
+
+	// MoveAttempts is the most places an unhappy agent tries under Random
+	// movement before giving up until it is next chosen; zero means twice
+	// Size. An agent that gives up stays unhappy, so too low a cap can keep
+	// a model from converging that otherwise would. See GaveUp.
+	MoveAttempts int
+
+	// ThresholdCount, if greater than zero, replaces the tolerance: an agent
+	// is happy if at least this many of its neighbors are of its own type,
+	// or more than this many if Strict, however many neighbors it has. It
+	// needs uniform Weights, and no tolerance may be set alongside it.
+	ThresholdCount int
+
+	// ConvergeEps, if greater than zero, counts a model as converged once
+	// fewer than this fraction of its agents are unhappy, rather than only
+	// once none are. It must be less than one.
+	ConvergeEps float64
+
+	// PlateauTicks, if greater than zero, gives up on a run that has
+	// reached a plateau: once PlateauTicks steps have passed without the
+	// number of unhappy agents falling by more than a fraction PlateauEps
+	// of the agents below its lowest level before them. See Plateaued.
+	PlateauTicks int
+	PlateauEps   float64
+
+	// ScanWorkers is the number of goroutines that share each scan of the
+	// whole model: finding the unhappy agents in New, and Segregation and
+	// Entropy. Zero or one means the scans are serial. Steps are always
+	// serial, so runs are the same whatever ScanWorkers is, but Segregation
+	// and Entropy are summed in a different order and may differ in the
+	// last few bits. Only models of hundreds of thousands of cells or more gain
+	// from it; smaller ones are scanned serially regardless.
+	ScanWorkers int
+
+	// TypeGlyphs, if set, gives the character String prints for each type
+	// of agent, indexed by type, in place of Glyphs. Snapshots always use
+	// Glyphs, so that ParseLayout can read them back.
+	TypeGlyphs string
+
+	// PrefixSums, if set, has a model on a ring or line count neighbors
+	// with prefix sums kept up to date as agents move, in time that grows
+	// with the logarithm of Size rather than with the vision; see prefix.go.
+	// Happiness comes out exactly the same. It pays off for visions of
+	// tens of cells or more, and needs Uniform weights.
+	PrefixSums bool
+
+	// Bitsets, like PrefixSums, has a model of two types on a ring or line
+	// count neighbors in bulk, from a bitset of the cells holding an agent
+	// and one of those holding the first type, counted 64 cells at a time
+	// with a popcount; see bitset.go. The time still grows with the vision,
+	// but much more slowly. The bitsets take two bits for each cell, on top
+	// of the cells themselves. It needs Uniform weights, and cannot be
+	// combined with PrefixSums.
+	Bitsets bool
+
+	// VisionLeft and VisionRight, if either is set, give different
+	// neighborhood sizes to the left and to the right of an agent on a ring
+	// or line, in place of Vision, which becomes the larger of the two.
+	VisionLeft  int
+	VisionRight int
+
+	// GroupTolerances, if set, gives the tolerance of each type of agent,
+	// indexed by type. Types beyond its length use Tolerance.
+	GroupTolerances []float64
+
+	// ToleranceDist, if set, replaces Tolerance with a threshold drawn
+	// independently for each agent.
+	ToleranceDist Distribution
+
+	// Shares, if set, gives the expected fraction of the agents of each
+	// type, with an entry for every type; otherwise each type is equally
+	// likely. The entries needn't add up to 1. With
+	// Exact, the agents are split between the types as nearly in these
+	// proportions as whole numbers allow, in random places; an agent that
+	// can't be split evenly goes to the lowest-numbered of the types with
+	// the largest remainder, so with two equal types and an odd number of
+	// agents, type 0 has one more.
+	Shares []float64
+	Exact  bool
+
+	// InitClusters, if set, starts the agents in this many blocks of equal
+	// length, in place of a random arrangement: the cells are split in
+	// storage order, which on a grid makes horizontal bands, and the blocks
+	// take each type in turn. Empty cells are then scattered at random as
+	// usual, so the blocks come out only about equal. On a ring, if the
+	// last block has the same type as the first, the two join up.
+	InitClusters int
+
+	// Layout, if set, is the initial contents of each cell, which must
+	// number Size, in place of a random arrangement. Density is then
+	// the fraction of the cells in Layout that are not Empty.
+	Layout []int
+
+	// OnStep, if set, is called at the end of every Step that moves an
+	// agent, with the tick the step ends, counted as RunToEquilibrium
+	// counts them. OnConverge, if set, is called once more by the Step
+	// that leaves the model converged. Neither may step the model.
+	OnStep     func(tick int64, m *Model)
+	OnConverge func(tick int64, m *Model)
+
+	// TrackChanges has a model keep, for each cell, the tick from which it
+	// has held what it holds now, for HeldSince and FirstFirewallTick; see
+	// firewall.go. It takes two more words of memory for each cell and a
+	// little time for each move.
+	TrackChanges bool
+}
+
+// Glyphs are the characters used to print agents of each type. A model may
+// have at most len(Glyphs) groups.
+const Glyphs = "XO#@%&*+"
+
+// Topologies. On a Ring, or a toroidal grid, the edges of the model wrap
+// around. On a Line, or a bounded grid, they don't, and agents near the edges
+// have fewer neighbors.
+const (
+	Ring = "ring"
+	Line = "line"
+)
+
+// Movement rules. Under Random an unhappy agent moves to random places until
+// it is happy; under Best it moves once, to the place where the fraction of
+// its neighbors of the same type is highest; under Swap it trades places
+// with an unhappy agent of another type, if that makes neither worse off.
+const (
+	Random = "random"
+	Best   = "best"
+	Swap   = "swap"
+)
+
+// Activation schemes. Under Async each tick moves one unhappy agent, chosen
+// at random; under Sync each tick moves every agent that was unhappy at the
+// start of the tick, all at once; under Sequential each tick moves the
+// unhappy agent in the lowest-numbered cell.
+const (
+	Async      = "async"
+	Sync       = "sync"
+	Sequential = "sequential"
+)
+
+// Neighbor weights. Under Uniform every neighbor within sight counts the
+// same; under Linear a neighbor at distance d counts (Vision+1-d)/Vision, so
+// weight falls off linearly from 1 next door to 1/Vision at the edge; under
+// Gaussian it counts exp(-d²/(2σ²)) with σ = Vision/2. On a grid the distance
+// is the one the Neighborhood is measured in.
+const (
+	Uniform  = "uniform"
+	Linear   = "linear"
+	Gaussian = "gaussian"
+)
+
+// Grid neighborhoods. Under Moore an agent sees every cell within Chebyshev
+// distance Vision, the larger of the horizontal and vertical distances: a
+// square of (2*Vision+1)²-1 cells around it. Under VonNeumann it sees every
+// cell within Manhattan distance Vision, the sum of the two: a diamond of
+// 2*Vision*(Vision+1) cells.
+const (
+	Moore      = "moore"
+	VonNeumann = "vonneumann"
+)
+
+// Empty marks a cell with no agent in it. Empty cells are printed as '.'.
+const Empty = -1
+
+// Model is a ring or line, or a grid, of cells holding either an agent,
+// whose type is one of the integers 0 through Groups-1, or Empty.
+type Model struct {
+	Config
+	agents     []int
+	tolerances []float64     // per-agent thresholds, parallel to agents; nil if all agents share Tolerance
+	anchored   []bool        // whether each agent is fixed in place, parallel to agents; nil if none are
+	empties    []int         // indices of the empty cells, in no particular order
+	unhappy    []int         // indices of the unhappy agents, in no particular order
+	slot       []int         // position of each cell in unhappy, or -1; parallel to agents
+	touched    []int         // cells moved into or out of since the unhappy set was last updated
+	moves      int64         // number of relocations so far
+	gaveUp     int64         // times an agent used up MoveAttempts still unhappy
+	typeCounts []cellCounter // for each type, counts of the cells holding it, with PrefixSums or Bitsets
+	occupied   cellCounter   // counts of the cells holding any agent, with PrefixSums or Bitsets
+	stuck      bool          // no unhappy agents can trade places under Swap
+	since      []int64       // tick from which each cell has held what it holds, with TrackChanges
+	held       []int         // what each cell held when since was last stamped, with TrackChanges
+	noted      []int         // cells that may have changed since they were last stamped
+	steps      int64         // number of calls to Step
+	fewest     int           // fewest unhappy agents there have been
+	fewestAt   int64         // step after which there were first that few
+	most       int           // most unhappy agents there have been between steps
+	plateauLow int           // unhappy agents as of the last progress of more than PlateauEps
+	plateauAt  int64         // step after which that was made
+	bounded    bool          // Topology == Line
+	weights    []float64     // weight of a neighbor at each distance from 0 to Vision
+	rng        *rand.Rand
+}
+
+func New(cfg Config, rng *rand.Rand) *Model {
+	// Return an initialized Schelling model, a slice of ints limited
+	// to the range [0, Groups) of an arbitary size, with a fraction 1-Density
+	// of the cells, chosen at random, left empty. A grid is stored row by row.
+	// All randomness in the model is drawn from rng, so a model should not
+	// be shared between goroutines. The configuration should pass Validate.
+
+	cfg = cfg.withDefaults()
+	m := &Model{Config: cfg, agents: make([]int, cfg.Size), bounded: cfg.Topology == Line, rng: rng}
+	m.weights = weightTable(m.Weights, m.Vision)
+	if m.Layout != nil {
+		copy(m.agents, m.Layout)
+		for i, x := range m.agents {
+			if x == Empty {
+				m.empties = append(m.empties, i)
+			}
+		}
+		m.Density = 1 - float64(len(m.empties))/float64(m.Size)
+	} else if m.InitClusters > 0 {
+		for i := range m.agents {
+			m.agents[i] = i * m.InitClusters / m.Size % m.Groups
+		}
+	} else if m.Shares != nil {
+		for i := range m.agents {
+			m.agents[i] = m.drawType()
+		}
+	} else {
+		for i := range m.agents {
+			m.agents[i] = rng.Intn(m.Groups)
+		}
+	}
+
+	// empty out a random selection of cells with a partial Fisher-Yates shuffle
+	numEmpty := m.Size - int(m.Density*float64(m.Size)+0.5)
+	if numEmpty > 0 && m.Layout == nil {
+		order := make([]int, m.Size)
+		for i := range order {
+			order[i] = i
+		}
+		for i := 0; i < numEmpty; i++ {
+			j := i + rng.Intn(m.Size-i)
+			order[i], order[j] = order[j], order[i]
+			m.agents[order[i]] = Empty
+		}
+		m.empties = order[:numEmpty]
+	}
+	if m.Exact && m.Layout == nil {
+		m.splitExactly()
+	}
+
+	if m.ToleranceDist.Kind != "" {
+		m.tolerances = make([]float64, m.Size)
+		for i := range m.tolerances {
+			m.tolerances[i] = m.ToleranceDist.draw(rng)
+		}
+	}
+
+	if m.Anchored > 0 {
+		m.anchorAgents()
+	}
+
+	if m.PrefixSums {
+		m.buildCounters(func(n int) cellCounter { return make(fenwick, n) })
+	} else if m.Bitsets {
+		m.buildBitsets()
+	}
+
+	if m.TrackChanges {
+		m.since = make([]int64, m.Size)
+		for i := range m.since {
+			m.since[i] = 1
+		}
+		m.held = append([]int(nil), m.agents...)
+	}
+
+	m.slot = make([]int, m.Size)
+	for i := range m.slot {
+		m.slot[i] = -1
+	}
+	unhappy := make([]bool, m.Size)
+	m.scan(func(_, lo, hi int) {
+		for i := lo; i < hi; i++ {
+			unhappy[i] = !m.isHappy(i) && !m.IsAnchored(i)
+		}
+	})
+	for i, u := range unhappy {
+		if u {
+			m.slot[i] = len(m.unhappy)
+			m.unhappy = append(m.unhappy, i)
+		}
+	}
+	m.fewest, m.most, m.plateauLow = len(m.unhappy), len(m.unhappy), len(m.unhappy)
+	return m
+}
+
+func (m *Model) String() string {
+	// Return the model one row of the grid to a line, each agent printed as
+	// the glyph of its type, from TypeGlyphs or else Glyphs, and each empty
+	// cell as '.'. A cell holding anything else, which a valid model never
+	// does, is printed as '?' rather than stopping the program.
+
+	if m.TypeGlyphs != "" {
+		return m.format(m.TypeGlyphs)
+	}
+	return m.format(Glyphs)
+}
+
+func (m *Model) format(glyphs string) string {
+	// Return the model as String does, with the given glyphs for the types.
+
+	var buffer bytes.Buffer
+
+	for i, x := range m.agents {
+		if i > 0 && i%m.Width == 0 { // start a new row of the grid
+			buffer.WriteString("\n")
+		}
+		switch {
+		case x == Empty:
+			buffer.WriteByte('.')
+		case x >= 0 && x < len(glyphs):
+			buffer.WriteByte(glyphs[x])
+		default:
+			buffer.WriteByte('?')
+		}
+	}
+
+	return buffer.String()
+}
+
+func (m *Model) RunToEquilibrium(maxTicks int) (ticks int64, ok bool) {
+	// Step the model until it converges, more than maxTicks ticks have
+	// passed, or it has Plateaued. Return the number of ticks taken and
+	// whether the model converged.
+
+	ticks, ok, _ = m.RunToEquilibriumContext(context.Background(), maxTicks)
+	return ticks, ok
+}
+
+// how many ticks pass between checks for cancellation
+const cancelCheckInterval = 256
+
+func (m *Model) RunToEquilibriumContext(ctx context.Context, maxTicks int) (ticks int64, ok bool, err error) {
+	// Like RunToEquilibrium, but give up early, returning the context's error,
+	// if ctx is cancelled. The context is checked every few hundred ticks.
+
+	ticks = 1
+	for !m.Converged() {
+		if ticks%cancelCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return ticks, false, err
+			}
+		}
+		m.Step()
+		ticks++
+		if (ticks > int64(maxTicks) || m.Plateaued()) && !m.Converged() {
+			return ticks, false, nil
+		}
+	}
+	return ticks, true, nil
+}
+
+func (m *Model) Agent(idx int) int {
+	// Return the type of the agent in cell idx, or Empty. On a grid, cell
+	// (x, y) is idx = y*Width + x.
+
+	return m.agents[idx]
+}
+
+func (m *Model) Moves() int64 {
+	// Return the number of times an agent has relocated. An agent that
+	// moves several times before it is happy counts several times.
+
+	return m.moves
+}
+
+func (m *Model) GaveUp() int64 {
+	// Return the number of times an agent moving at random tried
+	// MoveAttempts places without finding one it was happy in. A run that
+	// fails to converge with agents giving up may be held back by the cap
+	// rather than by the model itself.
+
+	return m.gaveUp
+}
+
+func (m *Model) CountDistinct() int64 {
+	// Identify coherent subpopulations, what Brandt et al call "firewalls."
+
+	if m.Dim == 2 {
+		return m.countDistinct2d()
+	}
+
+	// Empty cells are skipped over, so only changes of type between
+	// consecutive agents count.
+	first, val := Empty, Empty
+	x := int64(0)
+
+	for _, element := range m.agents {
+		if element == Empty {
+			continue
+		}
+		if first == Empty {
+			first = element
+		} else if val != element {
+			x++
+		}
+		val = element
+	}
+
+	switch {
+	case first == Empty: // no agents at all
+		return 0
+	case x == 0: // a single block, which may be a single agent
+		return 1
+	case m.bounded: // the blocks at either end are not joined up
+		return x + 1
+	case first != val: // wrap around
+		x++
+	}
+
+	return x
+}
+
+func (m *Model) Converged() bool {
+	// Return true if all agents in the model are happy, or fewer than a
+	// fraction ConvergeEps of them are unhappy, or if under Swap no unhappy
+	// agents can trade places to their benefit; else return false. A model
+	// with Beta never converges, as happy agents may still move.
+
+	if m.Beta > 0 {
+		return false
+	}
+	if m.ConvergeEps > 0 && m.UnhappyFraction() < m.ConvergeEps {
+		return true
+	}
+	return len(m.unhappy) == 0 || m.stuck
+}
+
+func (m *Model) Unhappy() int {
+	// Return the number of unhappy agents, leaving out anchored ones, which
+	// never move however they feel.
+
+	return len(m.unhappy)
+}
+
+func (m *Model) UnhappyFraction() float64 {
+	// Return the fraction of the agents that are unhappy, counting as
+	// Unhappy does, or zero if the model has no agents.
+
+	return m.fractionOfAgents(len(m.unhappy))
+}
+
+func (m *Model) MaxUnhappyFraction() float64 {
+	// Return the largest fraction of the agents that have been unhappy at
+	// once, at the start or after any step: how far from equilibrium the
+	// model has been.
+
+	return m.fractionOfAgents(m.most)
+}
+
+func (m *Model) fractionOfAgents(n int) float64 {
+	// Return n as a fraction of the agents in the model, or zero if it has
+	// none.
+
+	agents := m.Size - len(m.empties)
+	if agents == 0 {
+		return 0
+	}
+	return float64(n) / float64(agents)
+}
+
+func (m *Model) isHappy(idx int) bool {
+	// Return true if the proportion of nearby agents of the same type is greater than or equal to
+	// its tolerance threshold, or strictly greater if the model is Strict. The number of cells examined is given by the model's vision;
+	// empty cells among them, and on a line any beyond the ends, are ignored. Empty cells,
+	// and agents with no neighbors, are happy. With a ThresholdCount, the number of
+	// same-type neighbors is compared with it instead, and agents with too few neighbors are not.
+
+	if m.ThresholdCount > 0 {
+		if m.agents[idx] == Empty {
+			return true
+		}
+		count, _ := m.sameType(idx) // a whole number, under uniform weights
+		k := float64(m.ThresholdCount)
+		return count > k || (count == k && !m.Strict)
+	}
+
+	f := m.SameTypeFraction(idx, 0)
+	if math.IsNaN(f) { // an empty cell, or an agent with no neighbors
+		return true
+	}
+	// With uniform weights f is count/total for whole numbers count and
+	// total, and floating-point division is correctly rounded, so f is the
+	// float nearest that fraction. A tolerance such as 0.5 parses to the
+	// float nearest its decimal value, so the two are equal exactly when
+	// the fractions are, whatever the vision: 3/6, 2/4 and 1/2 all meet
+	// -t 0.5. Comparing count with t*total instead would round t*total, and
+	// comparing exactly with t's binary value would make 3/10 exceed 0.3.
+	t := m.threshold(idx)
+	return f > t || (f == t && !m.Strict)
+}
+
+func (m *Model) SameTypeFraction(idx, vision int) float64 {
+	// Return the fraction of the neighbors of the agent at idx that are of
+	// the same type, each counting for its weight, as isHappy compares with
+	// the agent's tolerance. With vision 0 the neighborhood is the model's
+	// own; otherwise it reaches vision cells in every direction, which must
+	// fit in the model as Validate requires of Vision. Return NaN if idx is
+	// empty or the agent has no neighbors. The model is not changed.
+
+	if m.agents[idx] == Empty {
+		return math.NaN()
+	}
+	var count, total float64
+	if vision == 0 {
+		count, total = m.sameType(idx)
+	} else {
+		count, total = m.sameTypeWithin(idx, vision, vision, weightTable(m.Weights, vision))
+	}
+	if total == 0 {
+		return math.NaN()
+	}
+	return count / total
+}
+
+func (m *Model) sameType(idx int) (count, total float64) {
+	// Return the number of neighbors of the agent at idx that are of the
+	// same type, and the number of neighbors of any type, each neighbor
+	// counting for its weight.
+
+	return m.sameTypeWithin(idx, m.VisionLeft, m.VisionRight, m.weights)
+}
+
+func (m *Model) sameTypeWithin(idx, left, right int, weights []float64) (count, total float64) {
+	// Like sameType, but with a neighborhood of left cells to the left and
+	// right to the right on a ring or line, or on a grid the Neighborhood
+	// of radius len(weights)-1, and weights indexed by distance.
+
+	if m.Dim == 2 {
+		return m.sameType2d(idx, weights)
+	}
+	if m.typeCounts != nil && m.agents[idx] != Empty { // every weight is 1
+		same := m.windowCount(m.typeCounts[m.agents[idx]], idx, left, right)
+		return float64(same), float64(m.windowCount(m.occupied, idx, left, right))
+	}
+
+	for x := 1; x <= max(left, right); x++ {
+		y := m.neighbor(idx, -x)
+		if x <= left && y >= 0 && m.agents[y] != Empty {
+			total += weights[x]
+			if m.agents[y] == m.agents[idx] {
+				count += weights[x]
+			}
+		}
+
+		y = m.neighbor(idx, x)
+		if x <= right && y >= 0 && m.agents[y] != Empty {
+			total += weights[x]
+			if m.agents[y] == m.agents[idx] {
+				count += weights[x]
+			}
+		}
+	}
+
+	return count, total
+}
+
+func weightTable(kind string, vision int) []float64 {
+	// Return the weight of a neighbor at each distance from 0 to vision
+	// under the given kind of Weights.
+
+	weights := make([]float64, vision+1)
+	for d := range weights {
+		switch kind {
+		case Linear:
+			weights[d] = float64(vision+1-d) / float64(vision)
+		case Gaussian:
+			sigma := float64(vision) / 2
+			weights[d] = math.Exp(-float64(d*d) / (2 * sigma * sigma))
+		default:
+			weights[d] = 1
+		}
+	}
+	return weights
+}
+
+func (m *Model) neighbor(idx, offset int) int {
+	// Return the index of the cell offset places away from idx, wrapping around
+	// a ring, or -1 if it falls off the end of a line.
+
+	y := idx + offset
+	if y < 0 || y >= len(m.agents) {
+		if m.bounded {
+			return -1
+		}
+		y %= len(m.agents)
+		if y < 0 {
+			y += len(m.agents)
+		}
+	}
+	return y
+}
+
+func (m *Model) Step() {
+	// Using random activation, pick an unhappy agent and
+	// tell it to move; with Sync activation, move all of them, and with
+	// Sequential, move the first one in index order. With Beta, give a
+	// random agent the chance to move instead.
+	// Do nothing if the model has converged.
+
+	if len(m.unhappy) == 0 && m.Beta == 0 {
+		return
+	}
+	switch {
+	case m.Beta > 0:
+		m.logitStep()
+	case m.Activation == Sync:
+		m.syncStep()
+	case m.Activation == Sequential:
+		// the unhappy set is unordered, but it is usually much smaller
+		// than the model, so search it rather than the cells
+		first := m.unhappy[0]
+		for _, idx := range m.unhappy[1:] {
+			if idx < first {
+				first = idx
+			}
+		}
+		m.move(first)
+	default:
+		m.move(m.unhappy[m.rng.Intn(len(m.unhappy))])
+	}
+
+	m.stamp()
+	m.steps++
+	if len(m.unhappy) < m.fewest {
+		m.fewest, m.fewestAt = len(m.unhappy), m.steps
+	}
+	m.most = max(m.most, len(m.unhappy))
+	if m.PlateauTicks > 0 && m.fractionOfAgents(m.plateauLow-len(m.unhappy)) > m.PlateauEps {
+		m.plateauLow, m.plateauAt = len(m.unhappy), m.steps
+	}
+	if m.OnStep != nil {
+		m.OnStep(m.steps+1, m)
+	}
+	if m.OnConverge != nil && m.Converged() {
+		m.OnConverge(m.steps+1, m)
+	}
+}
+
+func (m *Model) Plateaued() bool {
+	// Return true if the model has PlateauTicks, has not converged, and has
+	// gone that many steps without enough progress, as described for
+	// PlateauTicks. Runs stop early, unconverged, once it has.
+
+	return m.PlateauTicks > 0 && m.steps-m.plateauAt >= int64(m.PlateauTicks) && !m.Converged()
+}
+
+func (m *Model) StepsSinceProgress() int64 {
+	// Return the number of steps since the number of unhappy agents last
+	// fell to a new low. A model that keeps moving agents without this
+	// growing is getting nowhere: it is cycling among states, or wandering
+	// around a plateau, rather than slowly converging.
+
+	return m.steps - m.fewestAt
+}
+
+func (m *Model) move(idx int) {
+	// Move an unhappy agent to new places in the model at random until it is happy.
+	// TODO: IIRC, this is slightly more random than the Brandt model. Update comment with clarification.
+
+	if m.Movement == Best {
+		m.bestResponseMove(idx)
+		m.settle()
+		return
+	}
+	if m.Movement == Swap {
+		m.swapMove(idx)
+		return
+	}
+
+	tries := 0
+	unhappy := true
+
+	// give up after MoveAttempts tries, to avoid infinite loops
+	for unhappy && tries < m.MoveAttempts {
+		m.touched = append(m.touched, idx)
+		idx = m.relocateRandomly(idx)
+		m.touched = append(m.touched, idx)
+		m.settle() // before the next relocation shifts the touched cells
+		m.moves++
+
+		tries++
+		unhappy = !m.isHappy(idx) // evaluate the agent's happiness at the new location
+	}
+	if unhappy {
+		m.gaveUp++
+	}
+}
+
+func (m *Model) relocateRandomly(idx int) int {
+	// Move the agent at idx to a random place in the model, and return the
+	// index of the cell it moved to. The unhappy set is left to settle.
+
+	if len(m.empties) > 0 {
+		// swap places with a random empty cell
+		e := m.rng.Intn(len(m.empties))
+		to := m.empties[e]
+		m.swap(idx, to)
+		m.empties[e] = idx
+		idx = to
+	} else if m.Dim == 2 {
+		// trade places with the agent in a random other cell
+		to := m.rng.Intn(len(m.agents) - 1)
+		if to >= idx {
+			to++
+		}
+		m.swap(idx, to)
+		idx = to
+	} else {
+		// Pick one of the len gaps left by removing the agent. On a ring the
+		// gap after the last agent is the same as the gap before the first,
+		// so there are only len-1 to choose from.
+		gaps := len(m.agents)
+		if !m.bounded {
+			gaps--
+		}
+		to := m.rng.Intn(gaps)
+		m.relocate(idx, to)
+		idx = to
+	}
+	return idx
+}
+
+func (m *Model) relocate(from, to int) {
+	// Remove the agent at index from and reinsert it at index to, shifting the
+	// agents in between one place towards from. This is done in place, so
+	// the number of agents of each type never changes.
+	//
+	// The shift makes this O(|to-from|). A linked list would make relocation
+	// O(1), but agents would lose the constant-time access to their neighbors
+	// by index that isHappy and the unhappy set rely on, so models that need
+	// fast moves should have empty cells instead, which agents swap into in O(1).
+
+	if m.since != nil {
+		for p := min(from, to); p <= max(from, to); p++ {
+			m.note(p)
+		}
+	}
+	if m.typeCounts != nil {
+		// recount only the cells whose contents change, which in a model
+		// that has segregated is a few of them
+		step := 1
+		if from > to {
+			step = -1
+		}
+		for p := min(from, to); p <= max(from, to); p++ {
+			src := p + step // the cell whose contents move into p
+			if p == to {
+				src = from
+			}
+			m.recount(p, m.agents[p], m.agents[src])
+		}
+	}
+	rotate(m.agents, from, to)
+	if m.tolerances != nil {
+		rotate(m.tolerances, from, to)
+	}
+	if m.anchored != nil {
+		rotate(m.anchored, from, to)
+	}
+	rotate(m.slot, from, to)
+	for p := min(from, to); p <= max(from, to); p++ {
+		if m.slot[p] >= 0 {
+			m.unhappy[m.slot[p]] = p
+		}
+	}
+}
+
+func (m *Model) swap(i, j int) {
+	// Exchange the contents of cells i and j, along with any per-agent state.
+
+	m.note(i)
+	m.note(j)
+	m.unindex(i)
+	m.unindex(j)
+	m.agents[i], m.agents[j] = m.agents[j], m.agents[i]
+	m.index(i)
+	m.index(j)
+	if m.tolerances != nil {
+		m.tolerances[i], m.tolerances[j] = m.tolerances[j], m.tolerances[i]
+	}
+	if m.anchored != nil {
+		m.anchored[i], m.anchored[j] = m.anchored[j], m.anchored[i]
+	}
+	m.slot[i], m.slot[j] = m.slot[j], m.slot[i]
+	if m.slot[i] >= 0 {
+		m.unhappy[m.slot[i]] = i
+	}
+	if m.slot[j] >= 0 {
+		m.unhappy[m.slot[j]] = j
+	}
+}
+
+func rotate[T any](s []T, from, to int) {
+	// Move s[from] to s[to], shifting the elements in between by one place.
+
+	val := s[from]
+	if from < to {
+		copy(s[from:to], s[from+1:to+1])
+	} else {
+		copy(s[to+1:from+1], s[to:from])
+	}
+	s[to] = val
+}
@@ -0,0 +1,635 @@
+package schelling
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func newLayout(t testing.TB, s string, cfg Config) *Model {
+	// Return a model set up from cfg with the cells of s, as String prints
+	// them, laid out as a ring or line if s is one row, else as a grid. A
+	// configuration with no tolerance gets 0.5.
+
+	t.Helper()
+	layout, width, height, err := ParseLayout(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Layout = layout
+	if height > 1 {
+		cfg.Dim, cfg.Width, cfg.Height = 2, width, height
+	} else {
+		cfg.Size = width
+	}
+	if cfg.Tolerance == 0 && cfg.ThresholdCount == 0 && cfg.ToleranceDist.Kind == "" {
+		cfg.Tolerance = 0.5
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("%q: %v", s, err)
+	}
+	return New(cfg, rand.New(rand.NewSource(1)))
+}
+
+func TestIsHappy(t *testing.T) {
+	// The ring XOOXXOXX, with X type 0 and O type 1, so cells 0 and 7 are
+	// neighbors.
+	const ring = "XOOXXOXX"
+
+	tests := []struct {
+		name      string
+		layout    string
+		vision    int
+		tolerance float64
+		idx       int
+		same      float64
+		happy     bool
+	}{
+		{"first cell wraps left", ring, 1, 0.5, 0, 1.0 / 2, true},
+		{"last cell wraps right", ring, 1, 0.5, 7, 2.0 / 2, true},
+		{"type 1 beside its own type", ring, 1, 0.5, 1, 1.0 / 2, true},
+		{"type 1 among the other type", ring, 1, 0.5, 5, 0, false},
+		{"first cell, wider vision", ring, 2, 0.5, 0, 2.0 / 4, true},
+		{"last cell, wider vision", ring, 2, 0.6, 7, 2.0 / 4, false},
+		{"type 1, wider vision", ring, 2, 0.3, 2, 1.0 / 4, false},
+		{"vision 3 of 8", ring, 3, 0.5, 0, 3.0 / 6, true},
+		{"higher tolerance", ring, 3, 0.51, 0, 3.0 / 6, false},
+		// On a ring of 7 the widest neighborhood, 3 cells on each side,
+		// takes in every other agent exactly once.
+		{"neighborhoods meet", "XXOXOOO", 3, 0.3, 0, 2.0 / 6, true},
+		{"neighborhoods meet, last cell", "XXOXOOO", 3, 0.5, 6, 3.0 / 6, true},
+		{"neighborhoods meet, type 0", "XXOXOOO", 3, 0.5, 3, 2.0 / 6, false},
+		{"empty neighbors ignored", "XXO..X", 2, 0.5, 0, 2.0 / 3, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newLayout(t, tt.layout, Config{Vision: tt.vision, Tolerance: tt.tolerance})
+			if got := m.SameTypeFraction(tt.idx, 0); got != tt.same {
+				t.Errorf("SameTypeFraction(%d) = %v, want %v", tt.idx, got, tt.same)
+			}
+			if got := m.isHappy(tt.idx); got != tt.happy {
+				t.Errorf("isHappy(%d) = %v, want %v", tt.idx, got, tt.happy)
+			}
+		})
+	}
+}
+
+func TestIsHappyOverlap(t *testing.T) {
+	// A neighborhood that would wrap around a ring onto itself is rejected,
+	// rather than counting some agents twice.
+
+	for _, vision := range []int{4, 5, 7} {
+		layout, _, _, _ := ParseLayout("XOOXXOXX")
+		cfg := Config{Size: len(layout), Vision: vision, Tolerance: 0.5, Layout: layout}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("vision %d on a ring of %d: no error", vision, len(layout))
+		}
+	}
+}
+
+func TestIsHappyEmpty(t *testing.T) {
+	// Empty cells, and agents with no neighbors, are happy.
+
+	m := newLayout(t, "X..O..", Config{Vision: 1})
+	for idx := 0; idx < 6; idx++ {
+		if !m.isHappy(idx) {
+			t.Errorf("cell %d is unhappy", idx)
+		}
+	}
+}
+
+func FuzzMove(f *testing.F) {
+	// Move an unhappy agent of an arbitrary small model and check that the
+	// model keeps its length and its agents of each type, and holds nothing
+	// but agents and empty cells. Each byte of cells gives a cell, Empty or
+	// one of three types; the bits of options choose a line rather than a
+	// ring, Best or Swap movement, and prefix sums.
+
+	f.Add([]byte{1, 1, 2, 1, 2, 2, 1, 2}, uint(0), uint(1), uint8(0), int64(1))
+	f.Add([]byte{1, 0, 2, 3, 1, 2, 0, 3, 3, 1}, uint(4), uint(2), uint8(1), int64(2))
+	f.Add([]byte{2, 1, 1, 2, 2, 1, 0, 1, 2}, uint(7), uint(1), uint8(2), int64(3))
+	f.Add([]byte{1, 2, 1, 2, 0, 0, 1, 2, 1, 2}, uint(3), uint(3), uint8(4), int64(4))
+	f.Add([]byte{3, 2, 1, 1, 2, 3, 1, 2, 1}, uint(5), uint(2), uint8(8), int64(5))
+
+	f.Fuzz(func(t *testing.T, cells []byte, pos, vision uint, options uint8, seed int64) {
+		if len(cells) < 3 || len(cells) > 200 {
+			return
+		}
+		layout := make([]int, len(cells))
+		for i, c := range cells {
+			layout[i] = int(c%4) - 1
+		}
+		cfg := Config{
+			Size:       len(layout),
+			Groups:     3,
+			Vision:     1 + int(vision%uint((len(layout)-1)/2)),
+			Tolerance:  0.5,
+			Layout:     layout,
+			PrefixSums: options&8 != 0,
+		}
+		if options&1 != 0 {
+			cfg.Topology = Line
+		}
+		switch options >> 1 & 3 {
+		case 1:
+			cfg.Movement = Best
+		case 2:
+			cfg.Movement = Swap
+		}
+		if cfg.Validate() != nil {
+			return
+		}
+		m := New(cfg, rand.New(rand.NewSource(seed)))
+		if len(m.unhappy) == 0 {
+			return
+		}
+		before := m.Counts()
+
+		m.move(m.unhappy[pos%uint(len(m.unhappy))])
+
+		if len(m.agents) != len(layout) {
+			t.Fatalf("length changed from %d to %d", len(layout), len(m.agents))
+		}
+		for i, x := range m.agents {
+			if x != Empty && (x < 0 || x >= m.Groups) {
+				t.Fatalf("cell %d holds %d", i, x)
+			}
+		}
+		if after := m.Counts(); !slices.Equal(after, before) {
+			t.Fatalf("type counts changed from %v to %v", before, after)
+		}
+	})
+}
+
+func TestNoAgents(t *testing.T) {
+	// A model with no agents is rejected, but one built without validation
+	// still steps without hanging.
+
+	for _, cfg := range []Config{
+		{Size: 10, Vision: 1, Tolerance: 0.5, Density: 0.04},
+		{Size: 4, Vision: 1, Tolerance: 0.5, Layout: []int{Empty, Empty, Empty, Empty}},
+	} {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%+v: no error", cfg)
+		}
+		cfg.Beta = 1
+		m := New(cfg, rand.New(rand.NewSource(1)))
+		m.Step()
+		if m.Moves() != 0 {
+			t.Errorf("%+v: %d moves", cfg, m.Moves())
+		}
+	}
+}
+
+func TestMovesKeepCounts(t *testing.T) {
+	// The number of agents of each type never changes, over thousands of
+	// moves by every way an agent can move.
+
+	configs := map[string]Config{
+		"full ring":    {Size: 200, Vision: 3, Tolerance: 0.5},
+		"full line":    {Size: 200, Topology: Line, Vision: 3, Tolerance: 0.5},
+		"ring":         {Size: 200, Vision: 3, Tolerance: 0.5, Density: 0.8},
+		"full grid":    {Dim: 2, Width: 15, Height: 15, Vision: 1, Tolerance: 0.5},
+		"grid":         {Dim: 2, Width: 15, Height: 15, Vision: 1, Tolerance: 0.5, Density: 0.8},
+		"best":         {Size: 200, Vision: 3, Tolerance: 0.5, Density: 0.8, Movement: Best},
+		"swap":         {Size: 200, Vision: 3, Tolerance: 0.5, Movement: Swap},
+		"sync":         {Size: 200, Vision: 3, Tolerance: 0.5, Density: 0.8, Activation: Sync},
+		"three groups": {Size: 200, Groups: 3, Vision: 3, Tolerance: 0.4},
+		"prefix sums":  {Size: 200, Vision: 3, Tolerance: 0.5, PrefixSums: true},
+		"bitsets":      {Size: 200, Vision: 3, Tolerance: 0.5, Bitsets: true},
+	}
+	for name, cfg := range configs {
+		t.Run(name, func(t *testing.T) {
+			if err := cfg.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			rng := rand.New(rand.NewSource(1))
+			m := New(cfg, rng)
+			want := m.Counts()
+			for moves := int64(0); moves+m.Moves() < 5000; {
+				if m.Converged() {
+					moves += m.Moves()
+					m = New(cfg, rng)
+					want = m.Counts()
+					continue
+				}
+				m.Step()
+				if got := m.Counts(); !slices.Equal(got, want) {
+					t.Fatalf("after %d moves, counts %v, want %v", moves+m.Moves(), got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCountDistinctSmall(t *testing.T) {
+	// A model with no agents has no groups, and one with a single agent has
+	// one, wherever it is. Validate rejects a model with no agents, so these
+	// are built directly.
+
+	tests := []struct {
+		name   string
+		layout []int
+		dim    int
+		want   int64
+	}{
+		{"no cells", []int{}, 1, 0},
+		{"no agents", []int{Empty, Empty, Empty, Empty}, 1, 0},
+		{"no agents, grid", []int{Empty, Empty, Empty, Empty}, 2, 0},
+		{"single cell", []int{1}, 1, 1},
+		{"single agent", []int{Empty, Empty, 0, Empty}, 1, 1},
+		{"single agent, grid", []int{Empty, 1, Empty, Empty}, 2, 1},
+	}
+	for _, tt := range tests {
+		for _, topology := range []string{Ring, Line} {
+			cfg := Config{Size: len(tt.layout), Topology: topology, Vision: 1, Tolerance: 0.5, Layout: tt.layout}
+			if tt.dim == 2 {
+				cfg.Dim, cfg.Width, cfg.Height = 2, 2, 2
+			}
+			m := New(cfg, rand.New(rand.NewSource(1)))
+			if got := m.CountDistinct(); got != tt.want {
+				t.Errorf("%s, %s: CountDistinct() = %d, want %d", tt.name, topology, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestValidateVision(t *testing.T) {
+	// On a ring, a neighborhood that would reach around onto itself is
+	// rejected; on a line, which doesn't wrap, any vision up to the size is
+	// allowed.
+
+	tests := []struct {
+		size, vision int
+		topology     string
+		ok           bool
+	}{
+		{8, 3, Ring, true},
+		{8, 4, Ring, false}, // len/2
+		{8, 7, Ring, false}, // len-1
+		{9, 4, Ring, true},  // len/2, rounded down
+		{9, 8, Ring, false}, // len-1
+		{8, 4, Line, true},
+		{8, 7, Line, true},
+		{8, 9, Line, false},
+	}
+	for _, tt := range tests {
+		cfg := Config{Size: tt.size, Topology: tt.topology, Vision: tt.vision, Tolerance: 0.5}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("vision %d on a %s of %d: error %v", tt.vision, tt.topology, tt.size, err)
+		}
+	}
+
+	// with the widest vision, an agent at either end of a line sees every
+	// other agent once
+	m := newLayout(t, "XOOXXOXO", Config{Topology: Line, Vision: 7})
+	if got, want := m.SameTypeFraction(0, 0), 3.0/7; got != want {
+		t.Errorf("first agent: %v, want %v", got, want)
+	}
+	if got, want := m.SameTypeFraction(7, 0), 3.0/7; got != want {
+		t.Errorf("last agent: %v, want %v", got, want)
+	}
+}
+
+func TestValidateAnchored(t *testing.T) {
+	// Anchored agents need a cell left empty, counted as New counts them:
+	// a density that rounds up to every cell, or a full layout whatever the
+	// density, leaves none.
+
+	full, gap := []int{0, 1, 0, 1, 0, 1, 0, 1, 0, 1}, []int{0, 1, 0, 1, Empty, 1, 0, 1, 0, 1}
+	tests := []struct {
+		name    string
+		density float64
+		layout  []int
+		ok      bool
+	}{
+		{"density rounds to full", 0.96, nil, false},
+		{"one empty cell", 0.9, nil, true},
+		{"full", 1, nil, false},
+		{"full layout", 0.5, full, false},
+		{"layout with an empty cell", 0, gap, true},
+	}
+	for _, tt := range tests {
+		cfg := Config{Size: 10, Vision: 1, Tolerance: 0.5, Density: tt.density, Layout: tt.layout, Anchored: 0.5}
+		if err := cfg.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: error %v", tt.name, err)
+		}
+	}
+}
+
+func TestStrictBoundary(t *testing.T) {
+	// With tolerance 0.5, an agent with 2 of 4 neighbors of its own type is
+	// happy by default, which needs only meeting the tolerance, and unhappy
+	// when Strict, which needs exceeding it. The same holds for a threshold
+	// count of 2.
+
+	const ring = "XOXXOOX" // cell 2, an X, sees XO to its left and XO to its right
+	for _, tt := range []struct {
+		cfg   Config
+		happy bool
+	}{
+		{Config{Vision: 2, Tolerance: 0.5}, true},
+		{Config{Vision: 2, Tolerance: 0.5, Strict: true}, false},
+		{Config{Vision: 2, ThresholdCount: 2}, true},
+		{Config{Vision: 2, ThresholdCount: 2, Strict: true}, false},
+	} {
+		m := newLayout(t, ring, tt.cfg)
+		if got := m.SameTypeFraction(2, 0); got != 0.5 {
+			t.Fatalf("SameTypeFraction(2) = %v, want 0.5", got)
+		}
+		if got := m.isHappy(2); got != tt.happy {
+			t.Errorf("%+v: isHappy(2) = %v, want %v", tt.cfg, got, tt.happy)
+		}
+	}
+}
+
+func TestWeights(t *testing.T) {
+	// Weighted fractions against hand-computed ones. With vision 3, Linear
+	// weights are 1, 2/3 and 1/3 at distances 1 to 3, and Gaussian weights
+	// exp(-d²/4.5), with σ = 1.5.
+
+	g1, g2, g3 := math.Exp(-1/4.5), math.Exp(-4/4.5), math.Exp(-9/4.5)
+	tests := []struct {
+		name   string
+		layout string
+		cfg    Config
+		idx    int
+		same   float64
+	}{
+		// cell 3, an X, sees X O X at distances 1 to 3 on its left, and
+		// O O X on its right
+		{"uniform", "XOXXOOX", Config{Vision: 3}, 3, 3.0 / 6},
+		{"linear", "XOXXOOX", Config{Vision: 3, Weights: Linear}, 3, (1 + 1.0/3 + 1.0/3) / 4},
+		{"gaussian", "XOXXOOX", Config{Vision: 3, Weights: Gaussian}, 3, (g1 + 2*g3) / (2 * (g1 + g2 + g3))},
+		// the first cell of a line sees only O X X on its right
+		{"linear, end of a line", "XOXXOOX", Config{Vision: 3, Weights: Linear, Topology: Line}, 0, (2.0/3 + 1.0/3) / 2},
+		{"gaussian, end of a line", "XOXXOOX", Config{Vision: 3, Weights: Gaussian, Topology: Line}, 0, (g2 + g3) / (g1 + g2 + g3)},
+		// an empty cell counts for nothing, however close
+		{"linear, empty neighbor", "XO.XOOX", Config{Vision: 3, Weights: Linear}, 3, (1.0/3 + 1.0/3) / 3},
+		// the middle of a Moore grid sees 8 X at distance 1, and 4 O among
+		// 16 cells at distance 2, each weighing 1/2
+		{"linear grid", "OXXXO\nXXXXX\nXXOXX\nXXXXX\nOXXXO", Config{Vision: 2, Weights: Linear}, 12, 2.0 / 16},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newLayout(t, tt.layout, tt.cfg)
+			if got := m.SameTypeFraction(tt.idx, 0); math.Abs(got-tt.same) > 1e-15 {
+				t.Errorf("SameTypeFraction(%d) = %v, want %v", tt.idx, got, tt.same)
+			}
+		})
+	}
+}
+
+func TestAsymmetricVision(t *testing.T) {
+	// Separate left and right vision near the ends of a line and across the
+	// seam of a ring, counting cell by cell and from prefix sums. Each case
+	// shows the neighbors the agent sees, in order, with | in its place.
+
+	const cells = "XOOXXOXX"
+	tests := []struct {
+		topology    string
+		left, right int
+		idx         int
+		same        float64
+	}{
+		{Ring, 1, 3, 0, 2.0 / 4}, // X | O O X
+		{Ring, 1, 3, 7, 2.0 / 4}, // X | X O O
+		{Ring, 1, 3, 5, 0.0 / 4}, // X | X X X
+		{Ring, 3, 1, 0, 2.0 / 4}, // O X X | O
+		{Ring, 3, 1, 1, 1.0 / 4}, // X X X | O
+		{Ring, 3, 1, 7, 3.0 / 4}, // X O X | X
+		{Ring, 0, 2, 0, 0.0 / 2}, // | O O
+		{Ring, 0, 2, 7, 1.0 / 2}, // | X O
+		{Line, 1, 3, 0, 1.0 / 3}, // | O O X
+		{Line, 1, 3, 7, 1.0 / 1}, // X |
+		{Line, 1, 3, 6, 1.0 / 2}, // O | X
+		{Line, 3, 1, 0, 0.0 / 1}, // | O
+		{Line, 3, 1, 7, 2.0 / 3}, // X O X |
+		{Line, 3, 1, 1, 1.0 / 2}, // X | O
+	}
+	for _, tt := range tests {
+		for _, prefixSums := range []bool{false, true} {
+			cfg := Config{Topology: tt.topology, VisionLeft: tt.left, VisionRight: tt.right, PrefixSums: prefixSums}
+			m := newLayout(t, cells, cfg)
+			if got := m.SameTypeFraction(tt.idx, 0); got != tt.same {
+				t.Errorf("%s, left %d, right %d, prefix sums %t: SameTypeFraction(%d) = %v, want %v",
+					tt.topology, tt.left, tt.right, prefixSums, tt.idx, got, tt.same)
+			}
+		}
+	}
+}
+
+func TestToleranceBoundary(t *testing.T) {
+	// An agent whose fraction of same-type neighbors equals its tolerance
+	// exactly, such as 1/2, 2/4 and 3/6 against 0.5, meets it whatever the
+	// vision, and one with a neighbor fewer does not; when Strict, it takes
+	// a neighbor more. The agent is cell 0 of a ring of 2*vision+1 cells, so
+	// it sees every other cell.
+
+	for _, tt := range []struct {
+		tolerance float64
+		num, den  int // tolerance as a fraction in lowest terms
+	}{
+		{0.5, 1, 2},
+		{0.25, 1, 4},
+		{0.75, 3, 4},
+		{0.3, 3, 10},
+		{0.6, 3, 5},
+	} {
+		for vision := 1; vision <= 10; vision++ {
+			if 2*vision%tt.den != 0 {
+				continue
+			}
+			k := 2 * vision / tt.den * tt.num // same-type neighbors at the tolerance
+			happy := func(same int, strict bool) bool {
+				cells := "X" + strings.Repeat("X", same) + strings.Repeat("O", 2*vision-same)
+				return newLayout(t, cells, Config{Vision: vision, Tolerance: tt.tolerance, Strict: strict}).isHappy(0)
+			}
+			name := fmt.Sprintf("tolerance %v, vision %d", tt.tolerance, vision)
+			if !happy(k, false) {
+				t.Errorf("%s: %d of %d does not meet it", name, k, 2*vision)
+			}
+			if k > 0 && happy(k-1, false) {
+				t.Errorf("%s: %d of %d meets it", name, k-1, 2*vision)
+			}
+			if happy(k, true) {
+				t.Errorf("%s: %d of %d exceeds it", name, k, 2*vision)
+			}
+			if k < 2*vision && !happy(k+1, true) {
+				t.Errorf("%s: %d of %d does not exceed it", name, k+1, 2*vision)
+			}
+		}
+	}
+}
+
+func TestLineEdges(t *testing.T) {
+	// An agent near an end of a line is judged by the neighbors it has, not
+	// by 2*vision of them.
+
+	const line = "XXOOOXOX"
+	tests := []struct {
+		vision, idx int
+		same        float64
+	}{
+		{1, 0, 1.0 / 1},
+		{1, 7, 0.0 / 1},
+		{2, 0, 1.0 / 2},
+		{2, 7, 1.0 / 2},
+		{3, 0, 1.0 / 3},
+		{3, 7, 1.0 / 3},
+		{3, 1, 1.0 / 4},
+		{3, 4, 3.0 / 6},
+	}
+	for _, tt := range tests {
+		for _, prefixSums := range []bool{false, true} {
+			m := newLayout(t, line, Config{Topology: Line, Vision: tt.vision, PrefixSums: prefixSums})
+			if got := m.SameTypeFraction(tt.idx, 0); got != tt.same {
+				t.Errorf("vision %d, prefix sums %t: SameTypeFraction(%d) = %v, want %v", tt.vision, prefixSums, tt.idx, got, tt.same)
+			}
+		}
+	}
+}
+
+func TestRunToEquilibriumCap(t *testing.T) {
+	// A run that converges on the step that takes it past its cap has still
+	// converged, after the same number of ticks as without the cap; with a
+	// lower cap, it hasn't.
+
+	cfg := Config{Size: 200, Vision: 3, Tolerance: 0.5}
+	for seed := int64(1); seed <= 5; seed++ {
+		ticks, ok := New(cfg, rand.New(rand.NewSource(seed))).RunToEquilibrium(1_000_000)
+		if !ok {
+			t.Fatalf("seed %d: no equilibrium", seed)
+		}
+		capped := int(ticks - 1) // the last step crosses the cap
+		if got, ok := New(cfg, rand.New(rand.NewSource(seed))).RunToEquilibrium(capped); !ok || got != ticks {
+			t.Errorf("seed %d, cap %d: %d ticks, converged %t, want %d, true", seed, capped, got, ok, ticks)
+		}
+		if _, ok := New(cfg, rand.New(rand.NewSource(seed))).RunToEquilibrium(capped - 1); ok {
+			t.Errorf("seed %d, cap %d: converged", seed, capped-1)
+		}
+	}
+}
+
+func TestRunWithTrajectory(t *testing.T) {
+	// The frames of a trajectory are those of the same run stepped by hand,
+	// at every every ticks and at the end, and an OnStep set beforehand is
+	// still called after every step.
+
+	cfg := Config{Size: 60, Vision: 2, Tolerance: 0.5, Density: 0.9}
+	const every = 3
+	var steps int64
+	cfg.OnStep = func(int64, *Model) { steps++ }
+	m := New(cfg, rand.New(rand.NewSource(1)))
+	traj, ticks, ok := m.RunWithTrajectory(1000, every)
+	if !ok {
+		t.Fatal("no equilibrium")
+	}
+	if steps != ticks-1 {
+		t.Errorf("OnStep called %d times in %d ticks", steps, ticks)
+	}
+
+	cfg.OnStep = nil
+	m = New(cfg, rand.New(rand.NewSource(1)))
+	var want Trajectory
+	for tick := int64(1); ; tick++ {
+		if (tick-1)%every == 0 || m.Converged() {
+			want = append(want, m.Frame(tick))
+		}
+		if m.Converged() {
+			break
+		}
+		m.Step()
+	}
+	if len(traj) != len(want) {
+		t.Fatalf("%d frames, want %d", len(traj), len(want))
+	}
+	for i := range want {
+		if traj[i].Tick != want[i].Tick || !slices.Equal(traj[i].Agents, want[i].Agents) {
+			t.Errorf("frame %d at tick %d, want tick %d", i, traj[i].Tick, want[i].Tick)
+		}
+	}
+}
+
+func TestRunWithSampledTrajectory(t *testing.T) {
+	// Each sampled frame is the frame of the full trajectory of the same run
+	// at its tick, and a sample with room for every tick keeps them all.
+
+	cfg := Config{Size: 60, Vision: 2, Tolerance: 0.5, Density: 0.9}
+	full, ticks, _ := New(cfg, rand.New(rand.NewSource(1))).RunWithTrajectory(1000, 1)
+	for _, size := range []int{5, int(ticks)} {
+		m := New(cfg, rand.New(rand.NewSource(1)))
+		traj, got, _ := m.RunWithSampledTrajectory(1000, size, rand.New(rand.NewSource(2)))
+		if got != ticks {
+			t.Fatalf("size %d: %d ticks, want %d", size, got, ticks)
+		}
+		if len(traj) != min(size, len(full)) {
+			t.Errorf("size %d: %d frames", size, len(traj))
+		}
+		for i, f := range traj {
+			if i > 0 && f.Tick <= traj[i-1].Tick {
+				t.Errorf("size %d: frame %d at tick %d after tick %d", size, i, f.Tick, traj[i-1].Tick)
+			}
+			if !slices.Equal(f.Agents, full[f.Tick-1].Agents) {
+				t.Errorf("size %d: frame at tick %d differs from the run", size, f.Tick)
+			}
+		}
+	}
+}
+
+func TestBitsetCount(t *testing.T) {
+	// A bitset counts the bits set in every range of cells, within a word
+	// and across several, as counting them one by one does.
+
+	const n = 200
+	rng := rand.New(rand.NewSource(1))
+	b, set := newBitset(n), make([]bool, n)
+	for i := range set {
+		if set[i] = rng.Intn(2) == 0; set[i] {
+			b.add(i, 1)
+		}
+	}
+	b.add(3, 1) // setting a bit twice counts it once
+	b.add(3, 1)
+	set[3] = true
+	for lo := 0; lo < n; lo++ {
+		want := int32(0)
+		for hi := lo; hi < n; hi++ {
+			if set[hi] {
+				want++
+			}
+			if got := b.count(lo, hi); got != want {
+				t.Fatalf("count(%d, %d) = %d, want %d", lo, hi, got, want)
+			}
+		}
+	}
+}
+
+func TestNewConfig(t *testing.T) {
+	// Each option sets the fields it is named for, and options apply in order.
+
+	tests := []struct {
+		name string
+		opts []Option
+		want Config
+	}{
+		{"none", nil, Config{}},
+		{"ring", []Option{WithSize(100), WithVision(2), WithTolerance(0.5)}, Config{Size: 100, Vision: 2, Tolerance: 0.5}},
+		{"grid", []Option{WithGrid(4, 5), WithNeighborhood(VonNeumann)}, Config{Dim: 2, Width: 4, Height: 5, Size: 20, Neighborhood: VonNeumann}},
+		{"later wins", []Option{WithVision(2), WithVision(3)}, Config{Vision: 3}},
+		{"weights", []Option{WithWeights(Gaussian)}, Config{Weights: Gaussian}},
+		{"asymmetric vision", []Option{WithVisionLeftRight(1, 3)}, Config{VisionLeft: 1, VisionRight: 3}},
+		{"activation", []Option{WithActivation(Sync)}, Config{Activation: Sync}},
+		{"shares", []Option{WithShares(0.3, 0.7), WithExact()}, Config{Shares: []float64{0.3, 0.7}, Exact: true}},
+		{"layout", []Option{WithSize(4), WithLayout([]int{0, Empty, 1, 1})}, Config{Size: 4, Layout: []int{0, Empty, 1, 1}}},
+	}
+	for _, tt := range tests {
+		if got := NewConfig(tt.opts...); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
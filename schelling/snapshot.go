@@ -0,0 +1,71 @@
+package schelling
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+)
+
+// snapshotHeader starts each snapshot, followed by the tick it was taken at
+// and the model's Entropy at that tick.
+const snapshotHeader = "tick "
+
+// A Snapshot is the state of a model at one tick of a run.
+type Snapshot struct {
+	Tick          int64
+	Entropy       float64
+	Layout        []int
+	Width, Height int
+}
+
+func (m *Model) WriteSnapshot(w io.Writer, tick int64) error {
+	// Write the model's state at tick to w: a line holding the tick and the
+	// entropy, the model as String prints it with the standard Glyphs, and
+	// a blank line to end the snapshot.
+
+	_, err := fmt.Fprintf(w, "%s%d entropy %.6f\n%s\n\n", snapshotHeader, tick, m.Entropy(), m.format(Glyphs))
+	return err
+}
+
+func (m *Model) WriteFrame(w io.Writer, f Frame) error {
+	// Write a frame of the model's run as WriteSnapshot would have written
+	// the model at the frame's tick.
+
+	c := m.Config
+	c.Layout = f.Agents
+	return New(c, rand.New(rand.NewSource(0))).WriteSnapshot(w, f.Tick)
+}
+
+func IsSnapshots(s string) bool {
+	// Report whether s looks like snapshots written by WriteSnapshot, rather
+	// than a single model for ParseLayout.
+
+	return strings.HasPrefix(s, snapshotHeader)
+}
+
+func ParseSnapshots(s string) ([]Snapshot, error) {
+	// Parse a sequence of snapshots written by WriteSnapshot. Each snapshot's
+	// model is parsed as by ParseLayout, so it can be used as a Layout to
+	// start a new model from that point of the run.
+
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	var snapshots []Snapshot
+	for i, block := range strings.Split(strings.TrimSpace(s), "\n\n") {
+		header, model, _ := strings.Cut(block, "\n")
+		if !strings.HasPrefix(header, snapshotHeader) {
+			return nil, fmt.Errorf("snapshot %d does not start with %q", i+1, snapshotHeader)
+		}
+		var tick int64
+		var entropy float64
+		if _, err := fmt.Sscanf(header, snapshotHeader+"%d entropy %g", &tick, &entropy); err != nil {
+			return nil, fmt.Errorf("snapshot %d has a bad header %q: %v", i+1, header, err)
+		}
+		layout, width, height, err := ParseLayout(model)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot %d: %v", i+1, err)
+		}
+		snapshots = append(snapshots, Snapshot{Tick: tick, Entropy: entropy, Layout: layout, Width: width, Height: height})
+	}
+	return snapshots, nil
+}
@@ -0,0 +1,45 @@
+package schelling
+
+func (m *Model) swapMove(idx int) {
+	// Trade places between the unhappy agent at idx and another unhappy
+	// agent of a different type, if the trade leaves neither of them with a
+	// smaller fraction of same-type neighbors and gives at least one of them
+	// a larger one. Partners are tried in random order and the first such
+	// trade is made, not the best one. If the agent has no such partner and
+	// no other pair of unhappy agents has one either, the model is stuck,
+	// and counts as converged.
+
+	if m.swapWith(idx) {
+		return
+	}
+	for _, other := range m.unhappy {
+		if other != idx && m.swapWith(other) {
+			return
+		}
+	}
+	m.stuck = true
+}
+
+func (m *Model) swapWith(idx int) bool {
+	// Make an improving trade between idx and another unhappy agent, as
+	// described for swapMove, and report whether there was one.
+
+	order := m.rng.Perm(len(m.unhappy))
+	for _, k := range order {
+		other := m.unhappy[k]
+		if m.agents[other] == m.agents[idx] {
+			continue
+		}
+		before, otherBefore := m.score(idx), m.score(other)
+		m.swap(idx, other)
+		after, otherAfter := m.score(other), m.score(idx)
+		if after >= before && otherAfter >= otherBefore && (after > before || otherAfter > otherBefore) {
+			m.touched = append(m.touched, idx, other)
+			m.settle()
+			m.moves += 2
+			return true
+		}
+		m.swap(idx, other)
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package schelling
+
+func (m *Model) syncStep() {
+	// Move every unhappy agent at once. All of them leave their cells
+	// together, and then each takes one of the cells left vacant, counting
+	// the cells that were already empty, chosen by a random shuffle of the
+	// vacancies. So two agents never try to move into the same cell: which
+	// agent gets a cell that several would like is decided by the shuffle.
+	// An agent may land back where it started. Unlike Async, an agent moves
+	// once a tick whether or not its new place makes it happy, and the
+	// Movement rule is not used.
+
+	movers := len(m.unhappy)
+	vacancies := make([]int, 0, movers+len(m.empties))
+	vacancies = append(vacancies, m.unhappy...)
+	vacancies = append(vacancies, m.empties...)
+
+	// take the movers off the model
+	types := make([]int, movers)
+	var tolerances []float64
+	if m.tolerances != nil {
+		tolerances = make([]float64, movers)
+	}
+	for i, c := range vacancies[:movers] {
+		types[i] = m.agents[c]
+		if tolerances != nil {
+			tolerances[i] = m.tolerances[c]
+		}
+		m.unlist(c)
+		m.unindex(c)
+		m.agents[c] = Empty
+	}
+
+	// and put them back in random vacancies
+	from := append([]int(nil), vacancies[:movers]...)
+	m.rng.Shuffle(len(vacancies), func(i, j int) {
+		vacancies[i], vacancies[j] = vacancies[j], vacancies[i]
+	})
+	for i, to := range vacancies[:movers] {
+		m.agents[to] = types[i]
+		m.index(to)
+		if tolerances != nil {
+			m.tolerances[to] = tolerances[i]
+		}
+		if to != from[i] {
+			m.moves++
+		}
+	}
+	m.empties = append(m.empties[:0], vacancies[movers:]...)
+
+	for _, c := range vacancies {
+		m.note(c)
+	}
+	m.touched = append(m.touched, vacancies...)
+	m.settle()
+}
@@ -0,0 +1,101 @@
+package schelling
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Distribution describes a probability distribution from which each agent's
+// tolerance is drawn. It is written as "uniform:low,high" or "normal:mean,sd"
+// and satisfies flag.Value, so it can be set directly from the command line.
+// Draws are clamped to [0, 1]. The zero Distribution is unset.
+type Distribution struct {
+	Kind string  // "uniform" or "normal"
+	A    float64 // lower bound, or mean
+	B    float64 // upper bound, or standard deviation
+}
+
+func ParseDistribution(s string) (Distribution, error) {
+	// Parse a distribution written as "kind:a,b".
+
+	var d Distribution
+	kind, params, found := strings.Cut(s, ":")
+	if !found {
+		return d, fmt.Errorf("distribution %q is not of the form kind:a,b", s)
+	}
+	a, b, found := strings.Cut(params, ",")
+	if !found {
+		return d, fmt.Errorf("distribution %q needs two parameters", s)
+	}
+
+	var err error
+	d.Kind = kind
+	if d.A, err = strconv.ParseFloat(a, 64); err != nil {
+		return Distribution{}, fmt.Errorf("distribution %q: %v", s, err)
+	}
+	if d.B, err = strconv.ParseFloat(b, 64); err != nil {
+		return Distribution{}, fmt.Errorf("distribution %q: %v", s, err)
+	}
+
+	switch {
+	case kind != "uniform" && kind != "normal":
+		return Distribution{}, fmt.Errorf("unknown distribution %q, expected uniform or normal", kind)
+	case kind == "uniform" && (d.A < 0 || d.B > 1 || d.A > d.B):
+		return Distribution{}, fmt.Errorf("uniform bounds must satisfy 0 <= low <= high <= 1")
+	case kind == "normal" && d.B < 0:
+		return Distribution{}, fmt.Errorf("normal standard deviation must not be negative")
+	}
+	return d, nil
+}
+
+func (d Distribution) String() string {
+	if d.Kind == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%g,%g", d.Kind, d.A, d.B)
+}
+
+func (d *Distribution) Set(s string) error {
+	parsed, err := ParseDistribution(s)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+func (d Distribution) draw(rng *rand.Rand) float64 {
+	// Return a single draw from the distribution, clamped to [0, 1].
+
+	var x float64
+	if d.Kind == "uniform" {
+		x = d.A + (d.B-d.A)*rng.Float64()
+	} else {
+		x = d.A + d.B*rng.NormFloat64()
+	}
+	return min(max(x, 0), 1)
+}
+
+func (c Config) GroupTolerance(t int) float64 {
+	// Return the tolerance shared by agents of type t, ignoring ToleranceDist.
+
+	if t < len(c.GroupTolerances) {
+		return c.GroupTolerances[t]
+	}
+	return c.Tolerance
+}
+
+func (m *Model) threshold(idx int) float64 {
+	// Return the tolerance of the agent at idx. A per-agent tolerance takes
+	// precedence over a per-group one, which takes precedence over Tolerance.
+
+	if m.tolerances != nil {
+		return m.tolerances[idx]
+	}
+	if m.GroupTolerances != nil {
+		return m.GroupTolerance(m.agents[idx])
+	}
+	return m.Tolerance
+}
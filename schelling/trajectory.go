@@ -0,0 +1,113 @@
+package schelling
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// A Frame is the contents of every cell of a model at one tick, laid out as
+// in Layout.
+type Frame struct {
+	Tick   int64
+	Agents []int
+}
+
+// A Trajectory is a sequence of frames of one run, in order of tick.
+type Trajectory []Frame
+
+func (m *Model) Frame(tick int64) Frame {
+	// Return a copy of the model's cells, labelled with tick.
+
+	return Frame{Tick: tick, Agents: append([]int(nil), m.agents...)}
+}
+
+func (m *Model) watch(f func(tick int64)) (unwatch func()) {
+	// Call f after every step, after any OnStep, with the tick counted as
+	// RunToEquilibrium counts them from now, until unwatch is called.
+
+	prev := m.OnStep
+	tick := int64(1)
+	m.OnStep = func(t int64, m *Model) {
+		if prev != nil {
+			prev(t, m)
+		}
+		tick++
+		f(tick)
+	}
+	return func() { m.OnStep = prev }
+}
+
+func (m *Model) RunWithTrajectory(maxTicks, every int) (traj Trajectory, ticks int64, ok bool) {
+	// Like RunToEquilibrium, but also return the model's trajectory: a frame
+	// every every ticks, as well as at the start and the end of the run. A
+	// trajectory takes memory for Size cells for each frame, so long runs
+	// should sample sparingly. every less than 1 is taken as 1.
+
+	every = max(every, 1)
+	traj = append(traj, m.Frame(1))
+	unwatch := m.watch(func(tick int64) {
+		if (tick-1)%int64(every) == 0 {
+			traj = append(traj, m.Frame(tick))
+		}
+	})
+	ticks, ok = m.RunToEquilibrium(maxTicks)
+	unwatch()
+	if (ticks-1)%int64(every) != 0 { // the last tick has no frame yet
+		traj = append(traj, m.Frame(ticks))
+	}
+	return traj, ticks, ok
+}
+
+// A Sampler keeps a uniform random sample of a bounded number of the frames
+// of a run, however long the run turns out to be, by reservoir sampling:
+// every tick offered has the same chance of being in the sample at the end.
+type Sampler struct {
+	frames Trajectory
+	size   int
+	seen   int64
+	rng    *rand.Rand
+}
+
+func NewSampler(size int, rng *rand.Rand) *Sampler {
+	// Return a Sampler keeping at most size frames, drawing on rng to choose
+	// them. rng should not be the model's own, so that sampling leaves the
+	// run unchanged.
+
+	return &Sampler{frames: make(Trajectory, 0, size), size: size, rng: rng}
+}
+
+func (s *Sampler) Offer(m *Model, tick int64) {
+	// Offer the model's state at tick for the sample. Its cells are copied
+	// only if it is kept, into the memory of the frame it replaces.
+
+	s.seen++
+	if len(s.frames) < s.size {
+		s.frames = append(s.frames, m.Frame(tick))
+		return
+	}
+	if j := s.rng.Int63n(s.seen); j < int64(s.size) {
+		s.frames[j].Tick = tick
+		copy(s.frames[j].Agents, m.agents)
+	}
+}
+
+func (s *Sampler) Trajectory() Trajectory {
+	// Return the frames sampled so far, in order of tick.
+
+	traj := append(Trajectory(nil), s.frames...)
+	sort.Slice(traj, func(i, j int) bool { return traj[i].Tick < traj[j].Tick })
+	return traj
+}
+
+func (m *Model) RunWithSampledTrajectory(maxTicks, size int, rng *rand.Rand) (traj Trajectory, ticks int64, ok bool) {
+	// Like RunWithTrajectory, but return at most size frames sampled
+	// uniformly from every tick of the run by a Sampler drawing on rng, so
+	// memory stays bounded however many ticks the run takes.
+
+	s := NewSampler(size, rng)
+	s.Offer(m, 1)
+	unwatch := m.watch(func(tick int64) { s.Offer(m, tick) })
+	ticks, ok = m.RunToEquilibrium(maxTicks)
+	unwatch()
+	return s.Trajectory(), ticks, ok
+}
@@ -0,0 +1,73 @@
+package schelling
+
+// A model keeps the set of its unhappy agents up to date as agents move, so
+// that Step can pick one in constant time and Converged needn't scan every
+// agent. The set is a slice of cell indices, unhappy, together with slot,
+// which gives each cell's position in the slice. Because slot moves along
+// with the agents, the set follows an agent wherever it goes; only the
+// happiness of agents near where someone moved needs to be re-evaluated.
+
+func (m *Model) refresh(idx int) {
+	// Re-evaluate the happiness of the agent at idx, adding it to or removing
+	// it from the unhappy set as necessary. Anchored agents are never in the
+	// set, as they cannot move however unhappy they are.
+
+	unhappy := !m.isHappy(idx) && !m.IsAnchored(idx)
+	if unhappy && m.slot[idx] < 0 {
+		m.slot[idx] = len(m.unhappy)
+		m.unhappy = append(m.unhappy, idx)
+	} else if !unhappy && m.slot[idx] >= 0 {
+		m.unlist(idx)
+	}
+}
+
+func (m *Model) unlist(idx int) {
+	// Remove the cell idx, which must be in the unhappy set, from it.
+
+	last := m.unhappy[len(m.unhappy)-1]
+	m.unhappy[m.slot[idx]] = last
+	m.slot[last] = m.slot[idx]
+	m.unhappy = m.unhappy[:len(m.unhappy)-1]
+	m.slot[idx] = -1
+}
+
+func (m *Model) settle() {
+	// Bring the unhappy set up to date after agents have moved into or out of
+	// the touched cells, re-evaluating only the agents within sight of them.
+	// When an agent is removed from one gap and reinserted in another, the
+	// agents in between shift along by one, but they keep the same neighbors
+	// unless they are near either end, and they carry their slot with them.
+
+	for _, p := range m.touched {
+		m.refreshAround(p)
+	}
+	m.touched = m.touched[:0]
+}
+
+func (m *Model) refreshAround(idx int) {
+	// Re-evaluate the happiness of the agent at idx and of every agent that can see it.
+
+	if m.Dim == 2 {
+		x, y := idx%m.Width, idx/m.Width
+		for dy := -m.Vision; dy <= m.Vision; dy++ {
+			for dx := -m.Vision; dx <= m.Vision; dx++ {
+				if c := m.wrap2d(x+dx, y+dy); c >= 0 {
+					m.refresh(c)
+				}
+			}
+		}
+		return
+	}
+
+	// an agent to the left of idx sees it if idx is within its right
+	// window, and one to the right if idx is within its left window
+	m.refresh(idx)
+	for x := 1; x <= m.Vision; x++ {
+		if y := m.neighbor(idx, -x); x <= m.VisionRight && y >= 0 {
+			m.refresh(y)
+		}
+		if y := m.neighbor(idx, x); x <= m.VisionLeft && y >= 0 {
+			m.refresh(y)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package schelling
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestUnhappyIncremental(t *testing.T) {
+	// After every step, the unhappy set kept up to date as agents move must
+	// match the one a new model finds from scratch in the same cells.
+
+	configs := map[string]Config{
+		"ring":                       {Size: 40, Vision: 2, Tolerance: 0.5},
+		"ring, empties":              {Size: 40, Vision: 2, Tolerance: 0.5, Density: 0.8},
+		"line":                       {Size: 40, Topology: Line, Vision: 3, Tolerance: 0.6},
+		"line, empties":              {Size: 40, Topology: Line, Vision: 3, Tolerance: 0.6, Density: 0.8},
+		"asymmetric":                 {Size: 40, VisionLeft: 1, VisionRight: 3, Tolerance: 0.5, Density: 0.9},
+		"grid":                       {Dim: 2, Width: 9, Height: 7, Vision: 1, Tolerance: 0.5},
+		"bounded grid":               {Dim: 2, Width: 9, Height: 7, Topology: Line, Vision: 2, Tolerance: 0.5, Density: 0.85},
+		"von Neumann":                {Dim: 2, Width: 9, Height: 7, Neighborhood: VonNeumann, Vision: 2, Tolerance: 0.5, Density: 0.85},
+		"best":                       {Size: 40, Vision: 2, Tolerance: 0.5, Movement: Best},
+		"best, empties":              {Size: 40, Vision: 2, Tolerance: 0.5, Density: 0.8, Movement: Best},
+		"best, grid":                 {Dim: 2, Width: 9, Height: 7, Vision: 1, Tolerance: 0.5, Movement: Best},
+		"swap":                       {Size: 40, Vision: 2, Tolerance: 0.5, Movement: Swap},
+		"sync":                       {Size: 40, Vision: 2, Tolerance: 0.5, Density: 0.8, Activation: Sync},
+		"sequential":                 {Size: 40, Vision: 2, Tolerance: 0.5, Density: 0.8, Activation: Sequential},
+		"prefix sums":                {Size: 40, Vision: 3, Tolerance: 0.5, PrefixSums: true},
+		"prefix sums, line, empties": {Size: 40, Topology: Line, Vision: 3, Tolerance: 0.5, Density: 0.8, PrefixSums: true},
+		"prefix sums, best":          {Size: 40, Vision: 3, Tolerance: 0.5, Movement: Best, PrefixSums: true},
+		"prefix sums, sync":          {Size: 40, Vision: 3, Tolerance: 0.5, Density: 0.8, Activation: Sync, PrefixSums: true},
+		"bitsets":                    {Size: 200, Vision: 40, Tolerance: 0.5, Bitsets: true},
+		"bitsets, swap":              {Size: 200, Vision: 40, Tolerance: 0.5, Movement: Swap, Bitsets: true},
+		"bitsets, line, empties":     {Size: 200, Topology: Line, Vision: 40, Tolerance: 0.5, Density: 0.8, Bitsets: true},
+		"three groups":               {Size: 40, Groups: 3, Vision: 2, GroupTolerances: []float64{0.3, 0.5, 0.6}},
+		"threshold":                  {Size: 40, Vision: 2, ThresholdCount: 2, Strict: true, Density: 0.9},
+	}
+	for name, cfg := range configs {
+		t.Run(name, func(t *testing.T) {
+			if err := cfg.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			for seed := int64(0); seed < 20; seed++ {
+				m := New(cfg, rand.New(rand.NewSource(seed)))
+				for step := 0; step < 300 && !m.Converged(); step++ {
+					m.Step()
+					checkUnhappy(t, m, cfg)
+					if t.Failed() {
+						t.Fatalf("seed %d, step %d", seed, step)
+					}
+				}
+			}
+		})
+	}
+}
+
+func checkUnhappy(t *testing.T, m *Model, cfg Config) {
+	// Check the unhappy set of m against that of a new model with the same
+	// cells, and the slot of each cell against the set. With prefix sums or
+	// bitsets, which the new model has too, also check the neighbor counts
+	// of every cell against counting the cells one by one.
+
+	t.Helper()
+	cfg.Layout = slices.Clone(m.agents)
+	fresh := New(cfg, rand.New(rand.NewSource(0)))
+
+	got, want := slices.Sorted(slices.Values(m.unhappy)), slices.Sorted(slices.Values(fresh.unhappy))
+	if !slices.Equal(got, want) {
+		t.Errorf("unhappy %v, want %v", got, want)
+	}
+	for i, s := range m.slot {
+		if s >= 0 && m.unhappy[s] != i || s < 0 && slices.Contains(m.unhappy, i) {
+			t.Errorf("slot of cell %d is %d", i, s)
+		}
+	}
+
+	if m.typeCounts != nil {
+		naive := *m
+		naive.typeCounts, naive.occupied = nil, nil
+		for i := range m.agents {
+			same, total := m.sameType(i)
+			wantSame, wantTotal := naive.sameType(i)
+			if same != wantSame || total != wantTotal || m.isHappy(i) != naive.isHappy(i) {
+				t.Errorf("cell %d: %v of %v neighbors the same, want %v of %v", i, same, total, wantSame, wantTotal)
+			}
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package schelling
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+func (c Config) withDefaults() Config {
+	// Return the configuration with its zero values replaced by the
+	// defaults, and the size and shape of the model made consistent.
+
+	if c.Dim == 2 {
+		c.Size = c.Width * c.Height
+		if c.Neighborhood == "" {
+			c.Neighborhood = Moore
+		}
+	} else {
+		c.Dim, c.Width, c.Height = 1, c.Size, 1
+		if c.VisionLeft == 0 && c.VisionRight == 0 {
+			c.VisionLeft, c.VisionRight = c.Vision, c.Vision
+		} else {
+			c.Vision = max(c.VisionLeft, c.VisionRight)
+		}
+	}
+	if c.Groups == 0 {
+		c.Groups = 2
+	}
+	if c.Density == 0 {
+		c.Density = 1
+	}
+	if c.Topology == "" {
+		c.Topology = Ring
+	}
+	if c.Movement == "" {
+		c.Movement = Random
+	}
+	if c.Activation == "" {
+		c.Activation = Async
+	}
+	if c.Weights == "" {
+		c.Weights = Uniform
+	}
+	if c.MoveAttempts == 0 {
+		c.MoveAttempts = 2 * c.Size
+	}
+	return c
+}
+
+func (c Config) Resolved() Config {
+	// Return the configuration as New will use it, with the defaults filled in.
+
+	return c.withDefaults()
+}
+
+func (c Config) Validate() error {
+	// Return an error describing the first problem found with the
+	// configuration, or nil if it describes a model that New can build.
+	// Zero values that New replaces with defaults are allowed.
+
+	if c.Dim != 0 && c.Dim != 1 && c.Dim != 2 {
+		return errors.New("dimension must be 1 or 2")
+	}
+	if c.Dim == 2 && (c.Width <= 0 || c.Height <= 0) {
+		return errors.New("grid width and height must be greater than zero")
+	}
+	if c.Dim == 2 && c.Neighborhood != "" && c.Neighborhood != Moore && c.Neighborhood != VonNeumann {
+		return fmt.Errorf("neighborhood must be %s or %s", Moore, VonNeumann)
+	}
+	if c.Dim != 2 && c.Neighborhood != "" {
+		return errors.New("a neighborhood shape only applies to a grid")
+	}
+	if c.Dim == 2 && (c.VisionLeft != 0 || c.VisionRight != 0) {
+		return errors.New("separate left and right vision only applies to a ring or line")
+	}
+	if c.VisionLeft < 0 || c.VisionRight < 0 {
+		return errors.New("left and right vision cannot be negative")
+	}
+	c = c.withDefaults()
+
+	switch {
+	case c.Topology != Ring && c.Topology != Line:
+		return fmt.Errorf("topology must be %s or %s", Ring, Line)
+	case c.Movement != Random && c.Movement != Best && c.Movement != Swap:
+		return fmt.Errorf("movement must be %s, %s or %s", Random, Best, Swap)
+	case c.Activation != Async && c.Activation != Sync && c.Activation != Sequential:
+		return fmt.Errorf("activation must be %s, %s or %s", Async, Sync, Sequential)
+	case c.Weights != Uniform && c.Weights != Linear && c.Weights != Gaussian:
+		return fmt.Errorf("weights must be %s, %s or %s", Uniform, Linear, Gaussian)
+	case c.Activation == Sync && c.Movement != Random:
+		return fmt.Errorf("%s activation moves agents at random, so it can only be used with %s movement", Sync, Random)
+	case c.Beta < 0:
+		return errors.New("beta cannot be negative")
+	case c.Beta > 0 && (c.Movement != Random || c.Activation != Async):
+		return fmt.Errorf("stochastic moves need %s movement and %s activation", Random, Async)
+	case c.Size <= 0:
+		return errors.New("size must be greater than zero")
+	case c.Groups < 2 || c.Groups > len(Glyphs):
+		return fmt.Errorf("the number of groups must be between 2 and %d", len(Glyphs))
+	case c.Density <= 0 || c.Density > 1:
+		return errors.New("density must be greater than zero and at most one")
+	case c.Layout == nil && int(c.Density*float64(c.Size)+0.5) == 0:
+		return errors.New("density is too low for the model to have any agents")
+	case c.Vision <= 0:
+		return errors.New("vision must be greater than zero")
+	case c.Vision > c.Size:
+		return errors.New("vision cannot be greater than the number of agents")
+	case c.Dim == 2 && (2*c.Vision >= c.Width || 2*c.Vision >= c.Height):
+		return errors.New("the neighborhood cannot be wider than the grid")
+	case c.Dim == 1 && c.Topology == Ring && c.VisionLeft+c.VisionRight >= c.Size:
+		// the neighborhood would wrap around onto itself, counting some agents twice
+		return errors.New("the neighborhood cannot be wider than the ring")
+	}
+
+	if c.ThresholdCount != 0 {
+		neighbors := c.VisionLeft + c.VisionRight
+		if c.Dim == 2 && c.Neighborhood == VonNeumann {
+			neighbors = 2 * c.Vision * (c.Vision + 1)
+		} else if c.Dim == 2 {
+			neighbors = (2*c.Vision+1)*(2*c.Vision+1) - 1
+		}
+		switch {
+		case c.ThresholdCount < 0:
+			return errors.New("threshold count cannot be negative")
+		case c.Tolerance != 0 || c.GroupTolerances != nil || c.ToleranceDist.Kind != "":
+			return errors.New("a threshold count replaces the tolerance, so they cannot both be set")
+		case c.Weights != Uniform:
+			return fmt.Errorf("a threshold count counts neighbors, so it needs %s weights", Uniform)
+		case c.Beta > 0:
+			return errors.New("stochastic moves compare a fraction with the tolerance, so they cannot be used with a threshold count")
+		case c.ThresholdCount > neighbors:
+			return fmt.Errorf("threshold count cannot be more than the %d neighbors an agent has", neighbors)
+		}
+	} else if c.ToleranceDist.Kind == "" {
+		for t := 0; t < c.Groups; t++ {
+			if c.GroupTolerance(t) <= 0 || c.GroupTolerance(t) >= 1 {
+				return fmt.Errorf("tolerance of type %d agents must be greater than zero and less than one", t)
+			}
+		}
+	}
+
+	if c.Shares != nil {
+		if len(c.Shares) != c.Groups {
+			return fmt.Errorf("shares must have one entry for each of the %d groups", c.Groups)
+		}
+		total := 0.0
+		for _, share := range c.Shares {
+			if share < 0 {
+				return errors.New("shares cannot be negative")
+			}
+			total += share
+		}
+		if total == 0 {
+			return errors.New("shares cannot all be zero")
+		}
+	}
+
+	if c.InitClusters != 0 {
+		switch {
+		case c.InitClusters < 0:
+			return errors.New("the number of initial clusters cannot be negative")
+		case c.InitClusters > c.Size:
+			return errors.New("there cannot be more initial clusters than cells")
+		case c.Layout != nil || c.Shares != nil || c.Exact:
+			return errors.New("initial clusters cannot be combined with a layout, shares or an exact split")
+		}
+	}
+
+	if c.TypeGlyphs != "" {
+		if len(c.TypeGlyphs) < c.Groups {
+			return fmt.Errorf("glyphs must have a character for each of the %d groups", c.Groups)
+		}
+		for i := 0; i < len(c.TypeGlyphs); i++ {
+			g := c.TypeGlyphs[i]
+			if g <= ' ' || g > '~' || g == '.' || g == '?' {
+				return fmt.Errorf("glyph %q must be a printable ASCII character other than a space, '.' or '?'", g)
+			}
+			if strings.IndexByte(c.TypeGlyphs[:i], g) >= 0 {
+				return fmt.Errorf("glyph %q is given to more than one type", g)
+			}
+		}
+	}
+
+	if c.PrefixSums && (c.Dim != 1 || c.Weights != Uniform) {
+		return fmt.Errorf("prefix sums only apply to a ring or line with %s weights", Uniform)
+	}
+	if c.Bitsets && (c.Dim != 1 || c.Weights != Uniform || c.Groups != 2) {
+		return fmt.Errorf("bitsets only apply to two groups on a ring or line with %s weights", Uniform)
+	}
+	if c.Bitsets && c.PrefixSums {
+		return errors.New("choose either bitsets or prefix sums for counting neighbors, not both")
+	}
+
+	if c.ScanWorkers < 0 {
+		return errors.New("scan workers cannot be negative")
+	}
+	if c.MoveAttempts < 0 {
+		return errors.New("move attempts cannot be negative")
+	}
+	if c.ConvergeEps < 0 || c.ConvergeEps >= 1 {
+		return errors.New("the convergence tolerance must be at least zero and less than one")
+	}
+	if c.PlateauTicks < 0 {
+		return errors.New("plateau ticks cannot be negative")
+	}
+	if c.PlateauEps < 0 || c.PlateauEps >= 1 {
+		return errors.New("the plateau tolerance must be at least zero and less than one")
+	}
+	if c.PlateauEps > 0 && c.PlateauTicks == 0 {
+		return errors.New("a plateau tolerance needs plateau ticks")
+	}
+	if c.ConvergeEps > 0 && c.Beta > 0 {
+		return errors.New("a model with stochastic moves never converges, so cannot have a convergence tolerance")
+	}
+
+	if c.Anchored < 0 || c.Anchored >= 1 {
+		return errors.New("the anchored fraction must be at least zero and less than one")
+	}
+	if c.Anchored > 0 {
+		// as many cells are left empty as New leaves, after rounding
+		empty := c.Layout == nil && int(c.Density*float64(c.Size)+0.5) < c.Size
+		for _, x := range c.Layout {
+			empty = empty || x == Empty
+		}
+		if !empty {
+			return errors.New("anchored agents need empty cells for the others to move through")
+		}
+	}
+
+	if c.Layout != nil {
+		if len(c.Layout) != c.Size {
+			return fmt.Errorf("layout has %d cells, but the model has %d", len(c.Layout), c.Size)
+		}
+		agents := 0
+		for _, x := range c.Layout {
+			if x < Empty || x >= c.Groups {
+				return fmt.Errorf("layout has agents of type %d, but there are only %d groups", x, c.Groups)
+			}
+			if x != Empty {
+				agents++
+			}
+		}
+		if agents == 0 {
+			return errors.New("layout has no agents")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sdmccabe/schelling-go/schelling"
+)
+
+func runSnapshots(ctx context.Context, model *schelling.Model, maxTicks int, filename string, every int) (int64, bool, error) {
+	// Equivalent to model.RunToEquilibriumContext, but append a snapshot of
+	// the model to filename every every ticks, as well as at the start and
+	// the end. The file can be given to -init to start again from the last
+	// snapshot in it.
+
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+	out := bufio.NewWriter(f)
+	ticks, ok, err := runRecorded(ctx, model, maxTicks, every, func(ticks int64) {
+		if werr := model.WriteSnapshot(out, ticks); werr != nil {
+			log.Fatal(werr)
+		}
+	})
+	if werr := out.Flush(); werr != nil {
+		log.Fatal(werr)
+	}
+	if werr := f.Close(); werr != nil {
+		log.Fatal(werr)
+	}
+	return ticks, ok, err
+}
+
+func sampleSnapshots(ctx context.Context, model *schelling.Model, maxTicks int, filename string, sampler *schelling.Sampler) (int64, bool, error) {
+	// Equivalent to model.RunToEquilibriumContext, but offer every tick to
+	// sampler, and once the run ends append the snapshots it kept to
+	// filename, in order of tick.
+
+	ticks, ok, err := runRecorded(ctx, model, maxTicks, 1, func(ticks int64) { sampler.Offer(model, ticks) })
+	f, ferr := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if ferr != nil {
+		log.Fatal(ferr)
+	}
+	out := bufio.NewWriter(f)
+	for _, frame := range sampler.Trajectory() {
+		if werr := model.WriteFrame(out, frame); werr != nil {
+			log.Fatal(werr)
+		}
+	}
+	if werr := out.Flush(); werr != nil {
+		log.Fatal(werr)
+	}
+	if werr := f.Close(); werr != nil {
+		log.Fatal(werr)
+	}
+	return ticks, ok, err
+}
+
+func saveSnapshot(filename string, model *schelling.Model, ticks int64) error {
+	// Write the model at tick ticks to filename, replacing it, as a single
+	// snapshot, compressed with gzip if the name ends in .gz. -init can
+	// start a new run from it.
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if !strings.HasSuffix(filename, ".gz") {
+		err = model.WriteSnapshot(f, ticks)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}
+	zw := gzip.NewWriter(f)
+	err = model.WriteSnapshot(zw, ticks)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func dumpName(dir string, runNumber int, seed int64, compress bool) string {
+	// Return the name of the file -dump-final writes the final model of a
+	// run to, in dir.
+
+	name := fmt.Sprintf("run-%d-seed-%d.txt", runNumber, seed)
+	if compress {
+		name += ".gz"
+	}
+	return filepath.Join(dir, name)
+}
+
+func readModels(filename string) ([]schelling.Snapshot, error) {
+	// Read the models in filename: the snapshots written by -snapshot-file,
+	// -save or -dump-final, or a single model as printed by -v, taken to be
+	// at tick 1. A file whose name ends in .gz is decompressed first.
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var in io.Reader = f
+	if strings.HasSuffix(filename, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		in = zr
+	}
+	b, err := io.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	if schelling.IsSnapshots(string(b)) {
+		return schelling.ParseSnapshots(string(b))
+	}
+	layout, width, height, err := schelling.ParseLayout(string(b))
+	if err != nil {
+		return nil, err
+	}
+	return []schelling.Snapshot{{Tick: 1, Layout: layout, Width: width, Height: height}}, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import "math"
+
+func percentile(sorted []int64, p float64) float64 {
+	// Return the pth percentile (0 <= p <= 100) of a sorted slice, interpolating
+	// linearly between the nearest ranks. Return NaN if the slice is empty.
+
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(rank))
+	hi := int(math.Ceil(rank))
+	return float64(sorted[lo]) + (rank-float64(lo))*float64(sorted[hi]-sorted[lo])
+}
+
+func clusterStats(sizes []int) (min, max int, mean float64) {
+	// Return the smallest, largest and mean of the sizes of a model's groups,
+	// or -1 for each if there are none.
+
+	if len(sizes) == 0 {
+		return -1, -1, -1
+	}
+	min, max = sizes[0], sizes[0]
+	sum := 0
+	for _, size := range sizes {
+		if size < min {
+			min = size
+		}
+		if size > max {
+			max = size
+		}
+		sum += size
+	}
+	return min, max, float64(sum) / float64(len(sizes))
+}
+
+func fractionOf(t int, counts []int) float64 {
+	// Return the fraction of the agents counted in counts that are of type t.
+
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	if total == 0 {
+		return math.NaN()
+	}
+	return float64(counts[t]) / float64(total)
+}
+
+// running accumulates the mean and standard deviation of a stream of values
+// in constant memory, using Welford's online algorithm.
+type running struct {
+	n    int
+	mean float64
+	m2   float64 // sum of squared differences from the mean
+}
+
+func (r *running) add(x float64) {
+	r.n++
+	delta := x - r.mean
+	r.mean += delta / float64(r.n)
+	r.m2 += delta * (x - r.mean)
+}
+
+func (r *running) Mean() float64 {
+	// Return the mean of the values added so far, or NaN if there are none.
+
+	if r.n == 0 {
+		return math.NaN()
+	}
+	return r.mean
+}
+
+func (r *running) Sd() float64 {
+	// Return the sample standard deviation of the values added so far, or
+	// NaN if there are fewer than two.
+
+	if r.n < 2 {
+		return math.NaN()
+	}
+	return math.Sqrt(r.m2 / float64(r.n-1))
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// A parameter that can be swept, like -w or -t, is written as a
+// comma-separated list whose items are single values or inclusive ranges
+// start:stop:step, so "2:10:2" means 2,4,6,8,10 and "0.3,0.5,0.7" means
+// just those three values.
+
+func parseIntRange(s string) ([]int, error) {
+	// Parse a list of integers and integer ranges.
+
+	values, err := parseRange(s, func(s string) (float64, error) {
+		i, err := strconv.Atoi(s)
+		return float64(i), err
+	})
+	ints := make([]int, len(values))
+	for i, v := range values {
+		ints[i] = int(v)
+	}
+	return ints, err
+}
+
+func parseFloatRange(s string) ([]float64, error) {
+	// Parse a list of decimals and decimal ranges.
+
+	return parseRange(s, func(s string) (float64, error) {
+		return strconv.ParseFloat(s, 64)
+	})
+}
+
+func parseRange(s string, parse func(string) (float64, error)) ([]float64, error) {
+	// Parse a list of values and ranges, reading each number with parse.
+
+	var values []float64
+	for _, item := range strings.Split(s, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ":")
+		if len(parts) != 1 && len(parts) != 3 {
+			return nil, fmt.Errorf("%q is neither a value nor a range start:stop:step", item)
+		}
+		nums := make([]float64, len(parts))
+		for i, p := range parts {
+			n, err := parse(p)
+			if err != nil {
+				return nil, fmt.Errorf("%q: %v", item, err)
+			}
+			nums[i] = n
+		}
+		if len(nums) == 1 {
+			values = append(values, nums[0])
+			continue
+		}
+
+		start, stop, step := nums[0], nums[1], nums[2]
+		if step <= 0 || stop < start {
+			return nil, fmt.Errorf("range %q must have start <= stop and a positive step", item)
+		}
+		// count the steps up front, allowing for rounding, rather than
+		// accumulating the step and drifting past the stop; round each value
+		// so that 0.1:1:0.3 ends at 1, not 0.9999999999999999
+		n := int(math.Floor((stop-start)/step+1e-9)) + 1
+		for i := 0; i < n; i++ {
+			values = append(values, math.Round((start+float64(i)*step)*1e9)/1e9)
+		}
+	}
+	return values, nil
+}
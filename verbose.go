@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/sdmccabe/schelling-go/schelling"
+)
+
+// Values of -log-format.
+const (
+	logText = "text"
+	logJSON = "json"
+)
+
+// A tracer reports the progress of a run in verbose mode.
+type tracer interface {
+	start(r modelRun, model *schelling.Model)
+	tick(ticks int64, model *schelling.Model)
+	stalled(ticks int64)
+	end(r modelRun, ticks int64)
+}
+
+// plainTracer writes a run's progress to out as lines of text, printing the
+// whole model after every tick.
+type plainTracer struct {
+	out io.Writer
+}
+
+func (t plainTracer) start(r modelRun, model *schelling.Model) {
+	fmt.Fprintf(t.out, "Run number %d\n", r.runNumber)
+	fmt.Fprintf(t.out, "%d distinct groups at start\n", r.initGroups)
+	fmt.Fprintln(t.out, model)
+}
+
+func (t plainTracer) tick(ticks int64, model *schelling.Model) {
+	fmt.Fprintln(t.out, model)
+	if model.Dim == 2 { // separate successive grids
+		fmt.Fprintln(t.out)
+	}
+}
+
+func (t plainTracer) stalled(ticks int64) {
+	fmt.Fprintln(t.out, "Model failed to stabilize")
+}
+
+func (t plainTracer) end(r modelRun, ticks int64) {
+	fmt.Fprintf(t.out, "%d distinct groups at end after %d ticks and %d moves\n", r.finalGroups, ticks, r.moves)
+	fmt.Fprintln(t.out)
+}
+
+// slogTracer records a run's progress as structured log records, each
+// carrying the run number and an event name.
+type slogTracer struct {
+	logger *slog.Logger
+}
+
+func newSlogTracer(logger *slog.Logger, runNumber int) slogTracer {
+	return slogTracer{logger: logger.With("run", runNumber)}
+}
+
+func (t slogTracer) start(r modelRun, model *schelling.Model) {
+	t.logger.Info("run started", "event", "start", "tick", 1, "groups", r.initGroups, "model", model.String())
+}
+
+func (t slogTracer) tick(ticks int64, model *schelling.Model) {
+	t.logger.Info("tick", "event", "tick", "tick", ticks, "moves", model.Moves(), "model", model.String())
+}
+
+func (t slogTracer) stalled(ticks int64) {
+	t.logger.Warn("model failed to stabilize", "event", "stalled", "tick", ticks)
+}
+
+func (t slogTracer) end(r modelRun, ticks int64) {
+	t.logger.Info("run converged", "event", "end", "tick", ticks, "groups", r.finalGroups, "moves", r.moves)
+}
+
+func newLogger(logFormat string) *slog.Logger {
+	// Return a logger writing to standard error in the given -log-format,
+	// or nil if logFormat is empty, for plain verbose output.
+
+	switch logFormat {
+	case logText:
+		return slog.New(slog.NewTextHandler(os.Stderr, nil))
+	case logJSON:
+		return slog.New(slog.NewJSONHandler(os.Stderr, nil))
+	}
+	return nil
+}
+
+func runVerbose(ctx context.Context, model *schelling.Model, maxTicks int, t tracer) (int64, bool, error) {
+	// Equivalent to model.RunToEquilibriumContext, but report the model to t
+	// after every tick. Once ctx is cancelled, the ticks the run takes to
+	// notice go unreported.
+
+	onStep(model, func(ticks int64, m *schelling.Model) {
+		if ctx.Err() == nil {
+			t.tick(ticks, m)
+		}
+	})
+	ticks, ok, err := model.RunToEquilibriumContext(ctx, maxTicks)
+	if !ok && err == nil {
+		t.stalled(ticks)
+	}
+	return ticks, ok, err
+}
+
+// verboseMu keeps the verbose output of parallel runs from interleaving.
+var verboseMu sync.Mutex
+
+func printTagged(buffer *bytes.Buffer, tag string) {
+	// Print each line in the buffer to standard output with the tag in front
+	// of it, without any other output in between.
+
+	verboseMu.Lock()
+	defer verboseMu.Unlock()
+	for _, line := range strings.SplitAfter(buffer.String(), "\n") {
+		if line != "" {
+			fmt.Print(tag + line)
+		}
+	}
+}